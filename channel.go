@@ -1,10 +1,12 @@
 package gosocketio
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/mtfelian/golang-socketio/logging"
@@ -20,6 +22,7 @@ const (
 var (
 	ErrorSendTimeout     = errors.New("timeout")
 	ErrorSocketOverflood = errors.New("socket overflood")
+	ErrorChannelClosed   = errors.New("channel is closed")
 )
 
 // connectionHeader represents engine.io connection header
@@ -28,6 +31,7 @@ type connectionHeader struct {
 	Upgrades     []string `json:"upgrades"`
 	PingInterval int      `json:"pingInterval"`
 	PingTimeout  int      `json:"pingTimeout"`
+	MaxPayload   int64    `json:"maxPayload,omitempty"`
 }
 
 // Channel represents socket.io connection
@@ -40,26 +44,62 @@ type Channel struct {
 	connHeader connectionHeader
 
 	alive   bool
+	reason  DisconnectReason
 	aliveMu sync.Mutex
 
+	flushC chan struct{}
+
+	pongC    chan struct{}
+	lastSeen int64     // unix time in nanoseconds of the last received message, accessed atomically
+	rtt      int64     // last measured round trip time in nanoseconds, accessed atomically
+	lastPing time.Time // time of the last received ping, accessed by inLoop only
+
 	ack *acks
 
+	rateBuckets map[string]*tokenBucket // incoming events rate limiting buckets, accessed by inLoop only
+
+	events *event
+
+	rooms   map[string]struct{} // rooms joined by the channel
+	roomsMu sync.Mutex
+
+	userID   string
+	userMeta interface{}
+	userMu   sync.RWMutex
+
+	values *values
+
+	ctx    context.Context // cancelled when the channel closes
+	cancel context.CancelFunc
+
 	server  *Server
 	address string
 	header  http.Header
+	limitIP string // IP the connection is counted for by the server's connection limits
+
+	handshake *handshake
 }
 
 // init the Channel
 func (c *Channel) init() {
 	c.outC, c.stubC, c.upgradedC = make(chan string, queueBufferSize), make(chan string), make(chan string)
+	c.flushC, c.pongC = make(chan struct{}, 1), make(chan struct{}, 1)
+	c.rateBuckets = make(map[string]*tokenBucket)
+	c.lastSeen = time.Now().UnixNano()
 	c.ack = &acks{}
 	c.ack.ackC = make(map[int]chan string)
+	c.rooms = make(map[string]struct{})
+	c.values = newValues()
+	c.ctx, c.cancel = context.WithCancel(context.WithValue(context.Background(), channelContextKey{}, c))
 	c.alive = true
 }
 
 // Id returns an ID of the current socket connection
 func (c *Channel) Id() string { return c.connHeader.Sid }
 
+// Context returns a context of the channel which is cancelled when the channel closes
+func (c *Channel) Context() context.Context { return c.ctx }
+
 // IsAlive checks that Channel is still alive
 func (c *Channel) IsAlive() bool {
 	c.aliveMu.Lock()
@@ -68,13 +108,18 @@ func (c *Channel) IsAlive() bool {
 }
 
 // Close the client (Channel) connection
-func (c *Channel) Close() error { return c.close(c.server.event) }
+func (c *Channel) Close() error {
+	if c.server == nil {
+		return c.close(c.events, ReasonClientDisconnect)
+	}
+	return c.close(c.events, ReasonServerNamespaceDisconnect)
+}
 
 // stub closes the polling client (Channel) connection at socket.io upgrade
-func (c *Channel) stub() error { return c.close(nil) }
+func (c *Channel) stub() error { return c.close(nil, "") }
 
-// close channel
-func (c *Channel) close(e *event) error {
+// close channel with the given reason
+func (c *Channel) close(e *event, reason DisconnectReason) error {
 	switch c.conn.(type) {
 	case *transport.PollingConnection:
 		logging.Log().Debug("Channel.close() type: PollingConnection")
@@ -83,14 +128,14 @@ func (c *Channel) close(e *event) error {
 	}
 
 	c.aliveMu.Lock()
-	defer c.aliveMu.Unlock()
-
 	if !c.alive { // already closed
+		c.aliveMu.Unlock()
 		return nil
 	}
+	c.alive, c.reason = false, reason
+	c.aliveMu.Unlock()
 
 	c.conn.Close()
-	c.alive = false
 
 	// clean outloop
 	for len(c.outC) > 0 {
@@ -99,7 +144,9 @@ func (c *Channel) close(e *event) error {
 
 	if e != nil { // close
 		c.outC <- protocol.MessageClose
-		e.callHandler(c, OnDisconnection)
+		e.callHandler(c, OnDisconnection, reason)
+		c.values.clear()
+		c.cancel()
 	} else { // stub at transport upgrade
 		c.outC <- protocol.MessageStub
 	}
@@ -117,7 +164,17 @@ func (c *Channel) inLoop(e *event) error {
 		message, err := c.conn.GetMessage()
 		if err != nil {
 			logging.Log().Debugf("Channel.inLoop(), c.conn.GetMessage() err: %v, message: %s", err, message)
-			return c.close(e)
+			switch err {
+			case transport.ErrorConnectionClosed:
+				return c.close(e, ReasonTransportClose)
+			case transport.ErrorReceiveTimeout:
+				return c.close(e, ReasonPingTimeout)
+			case transport.ErrorPayloadTooLarge:
+				e.callError(c, "", ErrorTransport, err)
+				return c.close(e, ReasonPayloadTooLarge)
+			}
+			e.callError(c, "", ErrorTransport, err)
+			return c.close(e, ReasonTransportError)
 		}
 
 		if message == transport.StopMessage {
@@ -125,10 +182,13 @@ func (c *Channel) inLoop(e *event) error {
 			return nil
 		}
 
-		decodedMessage, err := protocol.Decode(message)
+		atomic.StoreInt64(&c.lastSeen, time.Now().UnixNano())
+
+		decodedMessage, err := e.parser.Decode(message)
 		if err != nil {
 			logging.Log().Debugf("Channel.inLoop() decoding err: %v, message: %s", err, message)
-			c.close(e)
+			e.callError(c, "", ErrorDecode, err)
+			c.close(e, ReasonParseError)
 			return err
 		}
 
@@ -136,9 +196,21 @@ func (c *Channel) inLoop(e *event) error {
 		case protocol.MessageTypeOpen:
 			logging.Log().Debugf("Channel.inLoop(), protocol.MessageTypeOpen, decodedMessage: %+v", decodedMessage)
 			if err := json.Unmarshal([]byte(decodedMessage.Source[1:]), &c.connHeader); err != nil {
-				c.close(e)
+				e.callError(c, "", ErrorDecode, err)
+				return c.close(e, ReasonParseError)
 			}
-			e.callHandler(c, OnConnection)
+			e.callHandler(c, OnConnection, nil)
+
+		case protocol.MessageTypeClose:
+			logging.Log().Debug("Channel.inLoop(), protocol.MessageTypeClose")
+			return c.close(e, ReasonTransportClose)
+
+		case protocol.MessageTypeDisconnect:
+			logging.Log().Debug("Channel.inLoop(), protocol.MessageTypeDisconnect")
+			if c.server == nil {
+				return c.close(e, ReasonServerDisconnect)
+			}
+			return c.close(e, ReasonClientNamespaceDisconnect)
 
 		case protocol.MessageTypePing:
 			logging.Log().Debugf("Channel.inLoop(), protocol.MessageTypePing, decodedMessage: %+v", decodedMessage)
@@ -148,17 +220,32 @@ func (c *Channel) inLoop(e *event) error {
 				c.upgradedC <- transport.UpgradedMessage
 			} else {
 				c.outC <- protocol.MessagePong
+				c.measurePing()
+			}
+
+		case protocol.MessageTypeEmpty:
+			if c.handshake != nil && decodedMessage.Args != "" {
+				c.handshake.setAuth(decodedMessage.Args)
+				c.server.auth.authenticated(c, json.RawMessage(decodedMessage.Args))
 			}
 
 		case protocol.MessageTypeUpgrade:
 		case protocol.MessageTypeBlank:
 		case protocol.MessageTypePong:
+			select {
+			case c.pongC <- struct{}{}:
+			default:
+			}
 		default:
+			if !e.allowIncoming(c, decodedMessage) {
+				if !c.IsAlive() { // disconnected by the rate limit policy
+					return nil
+				}
+				continue
+			}
 			go e.processIncoming(c, decodedMessage)
 		}
 	}
-
-	return nil
 }
 
 // outLoop is an outgoing events loop, sends messages from channel to socket
@@ -169,7 +256,8 @@ func (c *Channel) outLoop(e *event) error {
 		switch {
 		case outBufferLen >= queueBufferSize-1:
 			logging.Log().Debug("Channel.outLoop(), outBufferLen >= queueBufferSize-1")
-			return c.close(e)
+			e.callError(c, "", ErrorSocketOverflood, nil)
+			return c.close(e, ReasonOverflood)
 		case outBufferLen > int(queueBufferSize/2):
 			overfloodedMu.Lock()
 			overflooded[c] = struct{}{}
@@ -186,28 +274,26 @@ func (c *Channel) outLoop(e *event) error {
 			return nil
 		}
 
-		if err := c.conn.WriteMessage(m); err != nil {
-			logging.Log().Debug("Channel.outLoop(), failed to c.conn.WriteMessage() with err:", err)
-			return c.close(e)
+		if m == messageFlush {
+			select {
+			case c.flushC <- struct{}{}:
+			default:
+			}
+			continue
 		}
-	}
-	return nil
-}
 
-// pingLoop sends ping messages for keeping connection alive
-func (c *Channel) pingLoop() {
-	for {
-		interval, _ := c.conn.PingParams()
-		time.Sleep(interval)
-		if !c.IsAlive() {
-			return
+		if err := c.conn.WriteMessage(m); err != nil {
+			logging.Log().Debug("Channel.outLoop(), failed to c.conn.WriteMessage() with err:", err)
+			if err == transport.ErrorReceiveTimeout {
+				return c.close(e, ReasonPingTimeout)
+			}
+			e.callError(c, "", ErrorTransport, err)
+			return c.close(e, ReasonTransportError)
 		}
-
-		c.outC <- protocol.MessagePing
 	}
 }
 
-// send message packet to the given channel c with payload
+// send message packet to the given channel c with payload, json.RawMessage payload is sent as is
 func (c *Channel) send(m *protocol.Message, payload interface{}) error {
 	// preventing encoding/json "index out of range" panic
 	defer func() {
@@ -216,20 +302,27 @@ func (c *Channel) send(m *protocol.Message, payload interface{}) error {
 		}
 	}()
 
-	if payload != nil {
-		b, err := json.Marshal(&payload)
+	if raw, ok := payload.(json.RawMessage); ok {
+		m.Args = string(raw)
+	} else if payload != nil {
+		b, err := c.events.parser.Marshal(&payload)
 		if err != nil {
 			return err
 		}
 		m.Args = string(b)
 	}
 
-	command, err := protocol.Encode(m)
+	if m.Type == protocol.MessageTypeEmit || m.Type == protocol.MessageTypeAckRequest {
+		c.events.callAnyOutgoing(c, m.EventName, m.Args)
+	}
+
+	command, err := c.events.parser.Encode(m)
 	if err != nil {
 		return err
 	}
 
 	if len(c.outC) == queueBufferSize {
+		c.events.callError(c, m.EventName, ErrorSocketOverflood, nil)
 		return ErrorSocketOverflood
 	}
 
@@ -243,8 +336,22 @@ func (c *Channel) Emit(name string, payload interface{}) error {
 	return c.send(message, payload)
 }
 
+// EmitRaw an asynchronous event with the given name and raw payload, which is sent without encoding.
+// The payload should be encoded as the parser does, it's JSON with the default parser
+func (c *Channel) EmitRaw(name string, raw []byte) error {
+	message := &protocol.Message{Type: protocol.MessageTypeEmit, EventName: name, Args: string(raw)}
+	return c.send(message, nil)
+}
+
 // Ack a synchronous event with the given name and payload and wait for/receive the response
 func (c *Channel) Ack(name string, payload interface{}, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(c.ctx, timeout)
+	defer cancel()
+	return c.AckContext(ctx, name, payload)
+}
+
+// AckContext acts like Ack but waits for the response until ctx is done
+func (c *Channel) AckContext(ctx context.Context, name string, payload interface{}) (string, error) {
 	m := &protocol.Message{Type: protocol.MessageTypeAckRequest, AckID: c.ack.nextId(), EventName: name}
 
 	ackC := make(chan string)
@@ -252,47 +359,57 @@ func (c *Channel) Ack(name string, payload interface{}, timeout time.Duration) (
 
 	if err := c.send(m, payload); err != nil {
 		c.ack.unregister(m.AckID)
+		return "", err
 	}
 
 	select {
 	case result := <-ackC:
 		return result, nil
-	case <-time.After(timeout):
+	case <-ctx.Done():
 		c.ack.unregister(m.AckID)
-		return "", ErrorSendTimeout
+		if ctx.Err() == context.DeadlineExceeded {
+			return "", ErrorSendTimeout
+		}
+		return "", ctx.Err()
 	}
 }
 
-// IP returns an IP of the socket client
+// IP returns an IP of the socket client. Forwarding headers are respected for the server's trusted proxies
+// only, see Server.SetTrustedProxies
 func (c *Channel) IP() string {
-	forward := c.RequestHeader().Get(headerForward)
-	if forward != "" {
-		return forward
+	if c.server == nil {
+		return stripPort(c.address)
 	}
-	return c.address
+	return resolveIP(c.address, c.RequestHeader(), c.server.trustedProxies, c.server.ForwardingHeader)
 }
 
 // RequestHeader returns a connection request connectionHeader
 func (c *Channel) RequestHeader() http.Header { return c.header }
 
-// Join this channel to the given room
+// Join this channel to the given room, ErrorChannelClosed is returned if the channel is closed
 func (c *Channel) Join(room string) error {
 	if c.server == nil {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	if _, ok := c.server.channels[room]; !ok {
-		c.server.channels[room] = make(map[*Channel]struct{})
+	// join under the alive lock: a concurrently closed channel is either not joined or left by onDisconnection
+	c.aliveMu.Lock()
+	if !c.alive {
+		c.aliveMu.Unlock()
+		return ErrorChannelClosed
 	}
-
-	if _, ok := c.server.rooms[c]; !ok {
-		c.server.rooms[c] = make(map[string]struct{})
+	c.roomsMu.Lock()
+	c.rooms[room] = struct{}{}
+	joined, created := c.server.rooms.join(room, c)
+	c.roomsMu.Unlock()
+	c.aliveMu.Unlock()
+
+	if joined {
+		if uid, meta := c.User(); uid != "" {
+			c.server.presence.join(c, uid, meta, room)
+		}
+		c.server.hooks.joined(c, room, created)
 	}
-
-	c.server.channels[room][c], c.server.rooms[c][room] = struct{}{}, struct{}{}
 	return nil
 }
 
@@ -302,21 +419,74 @@ func (c *Channel) Leave(room string) error {
 		return ErrorServerNotSet
 	}
 
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
+	c.roomsMu.Lock()
+	delete(c.rooms, room)
+	left, deleted := c.server.rooms.leave(room, c)
+	c.roomsMu.Unlock()
 
-	if _, ok := c.server.channels[room]; ok {
-		delete(c.server.channels[room], c)
-		if len(c.server.channels[room]) == 0 {
-			delete(c.server.channels, room)
+	if left {
+		if uid, _ := c.User(); uid != "" {
+			c.server.presence.leave(c, uid, room)
 		}
+		c.server.hooks.left(c, room, deleted)
 	}
+	return nil
+}
 
-	if _, ok := c.server.rooms[c]; ok {
-		delete(c.server.rooms[c], room)
+// leaveAll rooms joined by the channel
+func (c *Channel) leaveAll() {
+	c.roomsMu.Lock()
+	rooms := c.rooms
+	c.rooms = make(map[string]struct{})
+	c.roomsMu.Unlock()
+
+	uid, _ := c.User()
+	for room := range rooms {
+		left, deleted := c.server.rooms.leave(room, c)
+		if !left {
+			continue
+		}
+		if uid != "" {
+			c.server.presence.leave(c, uid, room)
+		}
+		c.server.hooks.left(c, room, deleted)
 	}
+}
 
-	return nil
+// inherit the state of the channel old which is replaced with c at transport upgrade
+func (c *Channel) inherit(old *Channel) {
+	c.values = old.values
+	c.limitIP, c.handshake = old.limitIP, old.handshake
+	atomic.StoreInt64(&c.rtt, atomic.LoadInt64(&old.rtt))
+
+	// handlers still running on the old channel should be cancelled when the socket closes
+	go func() {
+		<-c.ctx.Done()
+		old.cancel()
+	}()
+
+	uid, meta := old.User()
+	c.userMu.Lock()
+	c.userID, c.userMeta = uid, meta
+	c.userMu.Unlock()
+	if uid != "" {
+		c.server.users.replace(uid, old, c)
+	}
+
+	old.roomsMu.Lock()
+	defer old.roomsMu.Unlock()
+
+	c.roomsMu.Lock()
+	defer c.roomsMu.Unlock()
+
+	for room := range old.rooms {
+		c.rooms[room] = struct{}{}
+		c.server.rooms.replace(room, old, c)
+		if uid != "" {
+			c.server.presence.replace(room, uid, old, c)
+		}
+	}
+	old.rooms = make(map[string]struct{})
 }
 
 // Amount returns an amount of channels joined to the given room, using channel
@@ -1,10 +1,13 @@
 package gosocketio
 
 import (
+	"crypto/tls"
+	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 
-	_ "time"
-
+	"github.com/mtfelian/golang-socketio/protocol"
 	"github.com/mtfelian/golang-socketio/transport"
 )
 
@@ -42,31 +45,107 @@ func AddrPolling(host string, port int, secure bool) string {
 	return prefix + host + ":" + strconv.Itoa(port) + socketioPollingURL
 }
 
+// DialOptions are options of the client connection, zero values mean defaults
+type DialOptions struct {
+	Path      string        // overrides the path of the address, like "/socket.io/"
+	Query     url.Values    // replaces values of the same keys in the query of the address
+	Header    http.Header   // added to the transport's headers of requests
+	Auth      interface{}   // payload of the socket.io connect packet, it's not sent if nil
+	TLSConfig *tls.Config   // overrides the transport's TLS configuration
+	Timeout   time.Duration // limits the connection establishing, zero means no limit
+	Parser    protocol.Parser
+}
+
 // Dial connects to server and initializes socket.io protocol
 // The correct ws protocol addr example:
 // ws://myserver.com/socket.io/?EIO=3&transport=websocket
 func Dial(addr string, tr transport.Transport) (*Client, error) {
+	return DialWithOptions(addr, tr, DialOptions{})
+}
+
+// DialWithParser connects to server like Dial and uses the given parser for packets and payloads
+func DialWithParser(addr string, tr transport.Transport, p protocol.Parser) (*Client, error) {
+	return DialWithOptions(addr, tr, DialOptions{Parser: p})
+}
+
+// DialWithOptions connects to server like Dial with the given options
+func DialWithOptions(addr string, tr transport.Transport, opts DialOptions) (*Client, error) {
+	if opts.Parser == nil {
+		opts.Parser = protocol.DefaultParser
+	}
+
+	addr, err := dialURL(addr, tr, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Client{Channel: &Channel{}, event: &event{}}
+	c.Channel.events = c.event
 	c.Channel.init()
 	c.event.init()
+	c.event.SetParser(opts.Parser)
 
-	var err error
-	c.conn, err = tr.Connect(addr)
+	if connector, ok := tr.(transport.OptionsConnector); ok {
+		c.conn, err = connector.ConnectWith(addr, transport.ConnectOptions{
+			Header:    opts.Header,
+			TLSConfig: opts.TLSConfig,
+			Timeout:   opts.Timeout,
+		})
+	} else {
+		c.conn, err = tr.Connect(addr)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	if opts.Auth != nil {
+		if err := c.send(&protocol.Message{Type: protocol.MessageTypeEmpty}, opts.Auth); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+	}
+
 	go c.Channel.inLoop(c.event)
 	go c.Channel.outLoop(c.event)
-	go c.Channel.pingLoop()
+	go c.Channel.pingLoop(c.event)
 
 	switch tr.(type) {
 	case *transport.PollingClientTransport:
-		go c.event.callHandler(c.Channel, OnConnection)
+		go c.event.callHandler(c.Channel, OnConnection, nil)
 	}
 
 	return c, nil
 }
 
+// dialURL returns addr with the path and query of opts applied, EIO and transport parameters are added if absent
+func dialURL(addr string, tr transport.Transport, opts DialOptions) (string, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", err
+	}
+	if opts.Path != "" {
+		u.Path = opts.Path
+	}
+
+	query := u.Query()
+	for key, values := range opts.Query {
+		query[key] = values
+	}
+	if query.Get("EIO") == "" {
+		query.Set("EIO", "3")
+	}
+	if query.Get("transport") == "" {
+		switch tr.(type) {
+		case *transport.WebsocketTransport:
+			query.Set("transport", "websocket")
+		case *transport.PollingClientTransport:
+			query.Set("transport", "polling")
+		}
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
 // Close client connection
-func (c *Client) Close() { c.Channel.close(c.event) }
+func (c *Client) Close() { c.Channel.close(c.event, ReasonClientDisconnect) }
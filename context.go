@@ -0,0 +1,23 @@
+package gosocketio
+
+import (
+	"context"
+)
+
+// channelContextKey is a key for the channel value in the channel context
+type channelContextKey struct{}
+
+// ChannelFromContext returns the channel the given handler context ctx is derived from
+func ChannelFromContext(ctx context.Context) (*Channel, bool) {
+	c, ok := ctx.Value(channelContextKey{}).(*Channel)
+	return c, ok
+}
+
+// SidFromContext returns an ID of the socket connection the given handler context ctx belongs to
+func SidFromContext(ctx context.Context) (string, bool) {
+	c, ok := ChannelFromContext(ctx)
+	if !ok {
+		return "", false
+	}
+	return c.Id(), true
+}
@@ -0,0 +1,68 @@
+package gosocketio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// DisconnectReason describes why the channel was disconnected
+type DisconnectReason string
+
+const (
+	ReasonTransportError            DisconnectReason = "transport error"
+	ReasonTransportClose            DisconnectReason = "transport close"
+	ReasonPingTimeout               DisconnectReason = "ping timeout"
+	ReasonParseError                DisconnectReason = "parse error"
+	ReasonOverflood                 DisconnectReason = "overflood"
+	ReasonPayloadTooLarge           DisconnectReason = "payload too large"
+	ReasonRateLimited               DisconnectReason = "rate limited"
+	ReasonServerShutdown            DisconnectReason = "server shutting down"
+	ReasonServerDisconnect          DisconnectReason = "io server disconnect"
+	ReasonServerNamespaceDisconnect DisconnectReason = "server namespace disconnect"
+	ReasonClientDisconnect          DisconnectReason = "io client disconnect"
+	ReasonClientNamespaceDisconnect DisconnectReason = "client namespace disconnect"
+)
+
+// messageFlush is an internal outgoing queue marker, outLoop signals flushC when it reaches the marker
+const messageFlush = "flush"
+
+// DisconnectReason returns a reason the channel was disconnected with, it's empty while the channel is alive
+func (c *Channel) DisconnectReason() DisconnectReason {
+	c.aliveMu.Lock()
+	defer c.aliveMu.Unlock()
+	return c.reason
+}
+
+// Disconnect the channel gracefully with the given reason: sends a disconnect packet so the remote side
+// knows the disconnection was intended, and then closes the connection
+func (c *Channel) Disconnect(reason DisconnectReason) error {
+	if !c.IsAlive() {
+		return nil
+	}
+
+	if err := c.send(&protocol.Message{Type: protocol.MessageTypeDisconnect}, nil); err == nil {
+		c.outC <- messageFlush
+		_, timeout := c.conn.PingParams()
+		select {
+		case <-c.flushC:
+		case <-time.After(timeout):
+		}
+	}
+
+	return c.close(c.events, reason)
+}
+
+// Shutdown disconnects all connected channels with ReasonServerShutdown
+func (s *Server) Shutdown() {
+	var wg sync.WaitGroup
+	for _, c := range s.sids.list() {
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+			c.Disconnect(ReasonServerShutdown)
+		}(c)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,51 @@
+package gosocketio
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrorHandlerPanic    = errors.New("handler panic")
+	ErrorPayloadMismatch = errors.New("payload type mismatch")
+	ErrorDecode          = errors.New("packet decoding failed")
+	ErrorTransport       = errors.New("transport error")
+	ErrorRateLimited     = errors.New("rate limit exceeded")
+)
+
+// ChannelError is an error delivered to OnError handlers. Kind is one of ErrorHandlerPanic,
+// ErrorPayloadMismatch, ErrorDecode, ErrorTransport, ErrorRateLimited or ErrorSocketOverflood,
+// Err is an underlying error
+type ChannelError struct {
+	Channel *Channel
+	Event   string // event name, empty if the error is not related to an event
+	Kind    error
+	Err     error
+}
+
+// Error implements error interface
+func (e *ChannelError) Error() string {
+	s := e.Kind.Error()
+	if e.Event != "" {
+		s = fmt.Sprintf("%s at event %q", s, e.Event)
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Is reports whether the error is of the given kind, for use with errors.Is
+func (e *ChannelError) Is(target error) bool { return e.Kind == target }
+
+// Unwrap returns an underlying error
+func (e *ChannelError) Unwrap() error { return e.Err }
+
+// PanicError represents a recovered handler panic
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements error interface
+func (e *PanicError) Error() string { return fmt.Sprintf("%v", e.Value) }
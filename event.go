@@ -1,9 +1,12 @@
 package gosocketio
 
 import (
-	"encoding/json"
+	"context"
 	"reflect"
+	"runtime/debug"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mtfelian/golang-socketio/logging"
 	"github.com/mtfelian/golang-socketio/protocol"
@@ -20,41 +23,175 @@ type systemEventHandler func(c *Channel)
 
 // event abstracts a mapping of a handler names to handler functions
 type event struct {
-	handlers   map[string]*handler // maps handler name to handler function representation
-	handlersMu sync.RWMutex
+	handlers    map[string][]*handler // maps handler name to handler function representations
+	patterns    []string              // handler names containing wildcards in order of registration
+	anyHandlers []AnyHandler
+	anyOutgoing []AnyHandler
+	handlersMu  sync.RWMutex
 
 	onConnection    systemEventHandler
 	onDisconnection systemEventHandler
+
+	parser protocol.Parser
+
+	rateLimit       RateLimit
+	eventRateLimits map[string]RateLimit
+
+	// AckTimeout limits the time of ack requests processing, a context passed to the ack request
+	// handler is cancelled after it passes. Zero value means no limit
+	AckTimeout time.Duration
+}
+
+// init initializes events mapping and sets the default parser
+func (e *event) init() {
+	e.handlers = make(map[string][]*handler)
+	e.parser = protocol.DefaultParser
 }
 
-// init initializes events mapping
-func (e *event) init() { e.handlers = make(map[string]*handler) }
+// Listener is a handle of a handler function registered by AddListener or Once, it removes the handler with Off
+type Listener struct {
+	name    string
+	handler *handler
+}
+
+// SetParser sets the parser used for packets and payloads, it should be set before connecting
+func (e *event) SetParser(p protocol.Parser) { e.parser = p }
 
-// On registers message processing function and binds it to the given event name
+// On registers message processing function and binds it to the given event name.
+// Several handlers may be bound to the same name, they are called in order of registration,
+// and the ack response is the result of the first of them returning a value.
+// The name may be a pattern with wildcards like "chat:*", handlers bound exactly to the incoming event name
+// take precedence over patterns, see matchHandlers for the precedence of patterns
 func (e *event) On(name string, f interface{}) error {
+	_, err := e.addHandler(name, f, false)
+	return err
+}
+
+// AddListener acts like On and returns the listener removing the handler with Off
+func (e *event) AddListener(name string, f interface{}) (*Listener, error) {
+	return e.addHandler(name, f, false)
+}
+
+// Once acts like AddListener but the handler is removed after the first call
+func (e *event) Once(name string, f interface{}) (*Listener, error) {
+	return e.addHandler(name, f, true)
+}
+
+// addHandler registers function f for the given event name
+func (e *event) addHandler(name string, f interface{}, once bool) (*Listener, error) {
 	c, err := newHandler(f)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	c.once = once
+
+	e.handlersMu.Lock()
+	if _, ok := e.handlers[name]; !ok && isPattern(name) {
+		e.patterns = append(e.patterns, name)
+	}
+	e.handlers[name] = append(e.handlers[name], c)
+	e.handlersMu.Unlock()
+
+	return &Listener{name: name, handler: c}, nil
+}
+
+// Off removes the handler registered by AddListener or Once which returned the listener l,
+// it does nothing if the handler is already removed
+func (e *event) Off(l *Listener) {
+	if l == nil {
+		return
 	}
+	e.removeHandler(l.name, l.handler)
+}
 
+// RemoveAllListeners removes all handlers bound to the given event names, or all handlers if no names given.
+// Catch-all handlers are not removed
+func (e *event) RemoveAllListeners(names ...string) {
 	e.handlersMu.Lock()
-	e.handlers[name] = c
+	defer e.handlersMu.Unlock()
+
+	if len(names) == 0 {
+		e.handlers, e.patterns = make(map[string][]*handler), nil
+		return
+	}
+
+	for _, name := range names {
+		for _, h := range e.handlers[name] {
+			e.removeHandlerLocked(name, h)
+		}
+	}
+}
+
+// removeHandler h from the given event name
+func (e *event) removeHandler(name string, h *handler) {
+	e.handlersMu.Lock()
+	e.removeHandlerLocked(name, h)
 	e.handlersMu.Unlock()
+}
+
+// removeHandlerLocked acts like removeHandler, should be called with handlersMu locked
+func (e *event) removeHandlerLocked(name string, h *handler) {
+	handlers := e.handlers[name]
+	for i := range handlers {
+		if handlers[i] != h {
+			continue
+		}
+
+		// copy to keep snapshots taken by callers unchanged
+		rest := make([]*handler, 0, len(handlers)-1)
+		rest = append(append(rest, handlers[:i]...), handlers[i+1:]...)
+		if len(rest) > 0 {
+			e.handlers[name] = rest
+			return
+		}
 
-	return nil
+		delete(e.handlers, name)
+		for j, pattern := range e.patterns {
+			if pattern == name {
+				e.patterns = append(e.patterns[:j:j], e.patterns[j+1:]...)
+				break
+			}
+		}
+		return
+	}
 }
 
-// findHandler returns a handler representation for the given event name
-// the second parameter is true if such event found.
-func (e *event) findHandler(name string) (*handler, bool) {
+// findHandlers returns handler representations bound exactly to the given event name
+func (e *event) findHandlers(name string) []*handler {
 	e.handlersMu.RLock()
-	f, ok := e.handlers[name]
-	e.handlersMu.RUnlock()
-	return f, ok
+	defer e.handlersMu.RUnlock()
+	return e.handlers[name]
 }
 
-// callHandler for the given channel c and event name
-func (e *event) callHandler(c *Channel, name string) {
+// invoke handler h bound to the given key with arguments, once-handlers are removed at the first invocation.
+// The second value is false if the handler was not called or it panicked
+func (e *event) invoke(ctx context.Context, c *Channel, key, name string, h *handler,
+	arguments interface{}) (result []reflect.Value, ok bool) {
+	if h.once {
+		if !atomic.CompareAndSwapInt32(&h.fired, 0, 1) {
+			return nil, false
+		}
+		e.removeHandler(key, h)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			result, ok = nil, false
+			if key == OnError { // reporting it would call the failed handler again
+				logging.Log().Warn("event.invoke(): recovered from OnError handler panic:", r)
+				return
+			}
+			e.callError(c, name, ErrorHandlerPanic, &PanicError{Value: r, Stack: debug.Stack()})
+		}
+	}()
+
+	return h.call(ctx, c, arguments), true
+}
+
+// callHandler for the given channel c and event name with args passed to the handlers if they accept them
+func (e *event) callHandler(c *Channel, name string, args interface{}) {
+	defer e.recoverHandler(c, name)
+
 	if e.onConnection != nil && name == OnConnection {
 		logging.Log().Debug("event.callHandler(): OnConnection handler")
 		e.onConnection(c)
@@ -64,70 +201,94 @@ func (e *event) callHandler(c *Channel, name string) {
 		e.onDisconnection(c)
 	}
 
-	f, ok := e.findHandler(name)
-	if !ok {
+	handlers := e.findHandlers(name)
+	if len(handlers) == 0 {
 		logging.Log().Debug("event.callHandler(): handler not found")
 		return
 	}
 
-	f.call(c, &struct{}{})
+	for _, f := range handlers {
+		arguments, _ := f.argumentsOf(args)
+		e.invoke(c.ctx, c, name, name, f, arguments)
+	}
+}
+
+// callError delivers the error of the given kind with underlying err to OnError handlers of channel c
+func (e *event) callError(c *Channel, name string, kind, err error) {
+	chErr := &ChannelError{Channel: c, Event: name, Kind: kind, Err: err}
+	logging.Log().Debug("event.callError():", chErr)
+
+	for _, f := range e.findHandlers(OnError) {
+		args, ok := f.argumentsOf(chErr)
+		if !ok {
+			logging.Log().Warnf("event.callError(): OnError handler can't accept %T", chErr)
+			continue
+		}
+
+		e.invoke(c.ctx, c, OnError, name, f, args)
+	}
+}
+
+// recoverHandler recovers from a panic in a handler of the given event name and reports it, should be deferred
+func (e *event) recoverHandler(c *Channel, name string) {
+	if r := recover(); r != nil {
+		e.callError(c, name, ErrorHandlerPanic, &PanicError{Value: r, Stack: debug.Stack()})
+	}
 }
 
 // processIncoming checks incoming message m on channel c
 func (e *event) processIncoming(c *Channel, m *protocol.Message) {
 	logging.Log().Debug("event.processIncoming() fired with:", m)
+	defer e.recoverHandler(c, m.EventName)
+
 	switch m.Type {
-	case protocol.MessageTypeEmit:
-		logging.Log().Debug("event.processIncoming() is finding handler for msg.Event:", m.EventName)
-		f, ok := e.findHandler(m.EventName)
-		if !ok {
+	case protocol.MessageTypeEmit, protocol.MessageTypeAckRequest:
+		e.callAny(c, m.EventName, m.Args)
+
+		logging.Log().Debug("event.processIncoming() is finding handlers for msg.Event:", m.EventName)
+		key, handlers := e.matchHandlers(m.EventName)
+		if len(handlers) == 0 {
 			logging.Log().Debug("event.processIncoming(): handler not found")
 			return
 		}
 
-		logging.Log().Debug("event.processIncoming() found handler:", f)
-
-		if !f.hasArgs {
-			f.call(c, &struct{}{})
-			return
+		ctx := c.ctx
+		if m.Type == protocol.MessageTypeAckRequest && e.AckTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, e.AckTimeout)
+			defer cancel()
 		}
 
-		data := f.arguments()
-		logging.Log().Debug("event.processIncoming(), f.arguments() returned:", data)
+		var response []reflect.Value
+		for _, f := range handlers {
+			data, raw := f.rawArguments(m)
+			if f.hasArgs && !raw {
+				// data type should be defined for Unmarshal()
+				data = f.arguments()
+				if err := e.parser.Unmarshal([]byte(m.Args), &data); err != nil {
+					logging.Log().Debugf("event.processIncoming() failed to Unmarshal(). msg.Args: %s, data: %v, err: %v",
+						m.Args, data, err)
+					e.callError(c, m.EventName, ErrorPayloadMismatch, err)
+					continue
+				}
+			}
 
-		if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
-			logging.Log().Infof("event.processIncoming() failed to json.Unmaeshal(). msg.Args: %s, data: %v, err: %v",
-				m.Args, data, err)
-			return
+			result, ok := e.invoke(ctx, c, key, m.EventName, f, data)
+			if ok && f.out && response == nil {
+				response = result
+			}
 		}
 
-		f.call(c, data)
-
-	case protocol.MessageTypeAckRequest:
-		logging.Log().Debug("event.processIncoming() ack request")
-		f, ok := e.findHandler(m.EventName)
-		if !ok || !f.out {
+		if m.Type != protocol.MessageTypeAckRequest || response == nil {
 			return
 		}
 
-		var result []reflect.Value
-		if f.hasArgs {
-			// data type should be defined for Unmarshal()
-			data := f.arguments()
-			if err := json.Unmarshal([]byte(m.Args), &data); err != nil {
-				return
-			}
-			result = f.call(c, data)
-		} else {
-			result = f.call(c, &struct{}{})
-		}
-
 		ackResponse := &protocol.Message{
 			Type:  protocol.MessageTypeAckResponse,
 			AckID: m.AckID,
 		}
 
-		c.send(ackResponse, result[0].Interface())
+		c.send(ackResponse, response[0].Interface())
 
 	case protocol.MessageTypeAckResponse:
 		logging.Log().Debug("event.processIncoming() ack response")
@@ -0,0 +1,72 @@
+package gosocketio
+
+import (
+	"errors"
+	"testing"
+)
+
+func newTestEvent() (*event, *Channel) {
+	e := &event{}
+	e.init()
+	c := &Channel{events: e}
+	c.init()
+	return e, c
+}
+
+func TestOffRemovesOnlyItsListener(t *testing.T) {
+	e, c := newTestEvent()
+
+	var calls []string
+	factory := func(subsystem string) func(c *Channel) {
+		return func(c *Channel) { calls = append(calls, subsystem) }
+	}
+
+	first, err := e.AddListener(OnConnection, factory("first"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.On(OnConnection, factory("second")); err != nil {
+		t.Fatal(err)
+	}
+
+	e.Off(first)
+	e.Off(first)
+	e.callHandler(c, OnConnection, nil)
+
+	if len(calls) != 1 || calls[0] != "second" {
+		t.Fatalf("calls are %v", calls)
+	}
+}
+
+func TestOnceOnError(t *testing.T) {
+	e, c := newTestEvent()
+
+	var once, always int
+	if _, err := e.Once(OnError, func(c *Channel, err *ChannelError) { once++ }); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.On(OnError, func(c *Channel, err *ChannelError) { always++ }); err != nil {
+		t.Fatal(err)
+	}
+
+	e.callError(c, "event", ErrorDecode, errors.New("first"))
+	e.callError(c, "event", ErrorDecode, errors.New("second"))
+
+	if once != 1 || always != 2 {
+		t.Fatalf("once handler is called %d times, other handler %d times", once, always)
+	}
+}
+
+func TestOnErrorHandlerPanic(t *testing.T) {
+	e, c := newTestEvent()
+
+	calls := 0
+	if err := e.On(OnError, func(c *Channel, err *ChannelError) { calls++; panic("failed") }); err != nil {
+		t.Fatal(err)
+	}
+
+	e.callError(c, "event", ErrorDecode, errors.New("error"))
+	if calls != 1 {
+		t.Fatalf("handler is called %d times", calls)
+	}
+}
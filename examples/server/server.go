@@ -24,8 +24,10 @@ const (
 	eventLeave = "leave"
 )
 
-func onConnectionHandler(c *gosocketio.Channel)    { log.Printf("Connected %s\n", c.Id()) }
-func onDisconnectionHandler(c *gosocketio.Channel) { log.Printf("Disconnected %s\n", c.Id()) }
+func onConnectionHandler(c *gosocketio.Channel) { log.Printf("Connected %s\n", c.Id()) }
+func onDisconnectionHandler(c *gosocketio.Channel, reason gosocketio.DisconnectReason) {
+	log.Printf("Disconnected %s: %s\n", c.Id(), reason)
+}
 func onJoinHandler(c *gosocketio.Channel, roomName string) string {
 	log.Printf("Join %s to room %s\n", c.Id(), roomName)
 	if err := c.Join(roomName); err != nil {
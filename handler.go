@@ -1,8 +1,12 @@
 package gosocketio
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"reflect"
+
+	"github.com/mtfelian/golang-socketio/protocol"
 )
 
 // handler is an event handler representation
@@ -10,15 +14,24 @@ type handler struct {
 	function reflect.Value
 	args     reflect.Type
 	hasArgs  bool
+	hasCtx   bool
 	out      bool
+	once     bool  // should be removed after the first call
+	fired    int32 // set to 1 at the first call of once-handler, accessed atomically
 }
 
 var (
 	ErrorHandlerIsNotFunc   = errors.New("f is not a function")
-	ErrorHandlerHasNot2Args = errors.New("f should have 1 or 2 arguments")
+	ErrorHandlerHasNot2Args = errors.New("f should have 1 or 2 arguments besides optional context")
 	ErrorHandlerWrongResult = errors.New("f should return no more than one value")
 )
 
+var (
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+	messageType    = reflect.TypeOf(&protocol.Message{})
+)
+
 // newHandler parses function f (event handler) using reflection, and stores it's representation
 func newHandler(f interface{}) (*handler, error) {
 	fVal := reflect.ValueOf(f)
@@ -36,12 +49,18 @@ func newHandler(f interface{}) (*handler, error) {
 		out:      fType.NumOut() == 1,
 	}
 
-	switch fType.NumIn() {
+	numIn, first := fType.NumIn(), 0
+	if numIn > 0 && fType.In(0) == contextType {
+		curCaller.hasCtx = true
+		numIn, first = numIn-1, 1
+	}
+
+	switch numIn {
 	case 1:
 		curCaller.args = nil
 		curCaller.hasArgs = false
 	case 2:
-		curCaller.args = fType.In(1)
+		curCaller.args = fType.In(first + 1)
 		curCaller.hasArgs = true
 	default:
 		return nil, ErrorHandlerHasNot2Args
@@ -53,16 +72,48 @@ func newHandler(f interface{}) (*handler, error) {
 // arguments returns function parameter as it is present in it using reflection
 func (h *handler) arguments() interface{} { return reflect.New(h.args).Interface() }
 
-// call func with given arguments from its representation using reflection
-func (h *handler) call(c *Channel, arguments interface{}) []reflect.Value {
-	// nil is untyped, so use the default empty value of correct type
-	if arguments == nil {
-		arguments = h.arguments()
+// rawArguments returns message m as a function parameter without decoding if the function accepts
+// json.RawMessage or *protocol.Message, the second value is false otherwise
+func (h *handler) rawArguments(m *protocol.Message) (interface{}, bool) {
+	switch h.args {
+	case rawMessageType:
+		raw := json.RawMessage(m.Args)
+		return &raw, true
+	case messageType:
+		return &m, true
 	}
+	return nil, false
+}
 
-	a := []reflect.Value{reflect.ValueOf(c), reflect.ValueOf(arguments).Elem()}
+// argumentsOf returns value v as a function parameter, the second value is false if v doesn't fit
+func (h *handler) argumentsOf(v interface{}) (interface{}, bool) {
 	if !h.hasArgs {
-		a = a[0:1]
+		return nil, true
+	}
+
+	val := reflect.ValueOf(v)
+	if !val.IsValid() || !val.Type().AssignableTo(h.args) {
+		return nil, false
+	}
+
+	arguments := reflect.New(h.args)
+	arguments.Elem().Set(val)
+	return arguments.Interface(), true
+}
+
+// call func with given context and arguments from its representation using reflection
+func (h *handler) call(ctx context.Context, c *Channel, arguments interface{}) []reflect.Value {
+	a := []reflect.Value{reflect.ValueOf(c)}
+	if h.hasArgs {
+		// nil is untyped, so use the default empty value of correct type
+		if arguments == nil {
+			arguments = h.arguments()
+		}
+		a = append(a, reflect.ValueOf(arguments).Elem())
+	}
+
+	if h.hasCtx {
+		a = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, a...)
 	}
 
 	return h.function.Call(a)
@@ -0,0 +1,98 @@
+package gosocketio
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Handshake describes the request the channel was established with, it's kept after the transport upgrade
+type Handshake struct {
+	Time      time.Time // time the channel was established at
+	Address   string    // remote address of the request
+	URL       *url.URL
+	Query     url.Values
+	Header    http.Header
+	Transport string // initial transport name, "polling" or "websocket"
+
+	Secure           bool                // true if the request was made over TLS
+	PeerCertificates []*x509.Certificate // certificates presented by the client over TLS
+
+	// Auth is a payload of the client's socket.io connect packet encoded as the parser does, it's JSON
+	// with the default parser and empty if the client sent no payload.
+	// It may arrive after the OnConnection handler is called, see Server.OnAuth
+	Auth json.RawMessage
+}
+
+// handshake holds the channel's handshake details shared with the upgraded channel
+type handshake struct {
+	Handshake
+	mu sync.RWMutex
+}
+
+// AuthHandler is a handler function of the auth payload of the client's connect packet
+type AuthHandler func(c *Channel, auth json.RawMessage)
+
+// authHooks holds auth payload handlers
+type authHooks struct {
+	onAuth []AuthHandler
+	mu     sync.RWMutex
+}
+
+// OnAuth registers f to be called when the client's connect packet with the auth payload arrives.
+// Events the client sent after the connect packet are processed after the handlers return
+func (s *Server) OnAuth(f AuthHandler) {
+	s.auth.mu.Lock()
+	s.auth.onAuth = append(s.auth.onAuth, f)
+	s.auth.mu.Unlock()
+}
+
+// authenticated fires handlers after the auth payload of channel c arrived
+func (h *authHooks) authenticated(c *Channel, auth json.RawMessage) {
+	h.mu.RLock()
+	onAuth := h.onAuth
+	h.mu.RUnlock()
+
+	for _, f := range onAuth {
+		callHook(c, func() { f(c, auth) })
+	}
+}
+
+// newHandshake returns handshake details of the request r
+func newHandshake(r *http.Request) *handshake {
+	h := &handshake{Handshake: Handshake{
+		Time:      time.Now(),
+		Address:   r.RemoteAddr,
+		URL:       r.URL,
+		Query:     r.URL.Query(),
+		Header:    r.Header,
+		Transport: r.URL.Query().Get("transport"),
+		Secure:    r.TLS != nil,
+	}}
+	if r.TLS != nil {
+		h.PeerCertificates = r.TLS.PeerCertificates
+	}
+	return h
+}
+
+// setAuth sets the auth payload
+func (h *handshake) setAuth(auth string) {
+	h.mu.Lock()
+	h.Auth = json.RawMessage(auth)
+	h.mu.Unlock()
+}
+
+// Handshake returns details of the request the channel was established with,
+// it's zero for client channels
+func (c *Channel) Handshake() Handshake {
+	if c.handshake == nil {
+		return Handshake{}
+	}
+
+	c.handshake.mu.RLock()
+	defer c.handshake.mu.RUnlock()
+	return c.handshake.Handshake
+}
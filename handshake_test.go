@@ -0,0 +1,73 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+func TestOnAuth(t *testing.T) {
+	s := NewServer()
+	authC := make(chan json.RawMessage, 1)
+	s.OnAuth(func(c *Channel, auth json.RawMessage) {
+		time.Sleep(50 * time.Millisecond) // events sent after the connect packet wait for the handler
+		authC <- auth
+	})
+	s.OnAuth(func(c *Channel, auth json.RawMessage) { panic("auth") })
+	afterC := make(chan json.RawMessage, 1)
+	s.On("after", func(c *Channel) { afterC <- c.Handshake().Auth })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	client, err := DialWithOptions(strings.Replace(ts.URL, "http://", "ws://", 1), transport.DefaultWebsocketTransport(),
+		DialOptions{Query: url.Values{"EIO": {"3"}}, Auth: map[string]string{"token": "secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.Emit("after", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `{"token":"secret"}`
+	select {
+	case auth := <-afterC:
+		t.Fatalf("event is processed before the auth handler returned, auth is %q", auth)
+	case auth := <-authC:
+		if string(auth) != want {
+			t.Fatalf("auth handler is called with %q, expected %q", auth, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("auth handler is not called")
+	}
+	select {
+	case auth := <-afterC:
+		if string(auth) != want {
+			t.Fatalf("handshake auth is %q in the event handler, expected %q", auth, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event sent after the connect packet is not processed")
+	}
+}
+
+func TestDialQueryReplacesKeys(t *testing.T) {
+	addr, err := dialURL("ws://localhost/socket.io/?EIO=3&token=old&room=a", transport.DefaultWebsocketTransport(),
+		DialOptions{Query: url.Values{"token": {"new"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := u.Query()
+	if query["token"][0] != "new" || len(query["token"]) != 1 || query.Get("room") != "a" ||
+		query.Get("transport") != "websocket" {
+		t.Fatalf("dial address is %q", addr)
+	}
+}
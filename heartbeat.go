@@ -0,0 +1,90 @@
+package gosocketio
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// RTT returns the last measured round trip time of ping-pong exchange, it's zero until measured.
+// The client measures it from ping to pong. The server estimates it from the time between pings:
+// the client sends the next ping ping interval after receiving a pong, so the time between pings
+// exceeds the ping interval by the round trip time. It's not measured if the client pings more often
+// than the server's ping interval
+func (c *Channel) RTT() time.Duration { return time.Duration(atomic.LoadInt64(&c.rtt)) }
+
+// measurePing estimates the round trip time on the received ping, the previous ping time is accessed by inLoop only
+func (c *Channel) measurePing() {
+	now := time.Now()
+	if !c.lastPing.IsZero() {
+		interval, _ := c.conn.PingParams()
+		if rtt := now.Sub(c.lastPing) - interval; rtt >= 0 {
+			atomic.StoreInt64(&c.rtt, int64(rtt))
+		}
+	}
+	c.lastPing = now
+}
+
+// pingLoop sends ping messages for keeping connection alive,
+// closes the channel if the pong is not received within ping timeout
+func (c *Channel) pingLoop(e *event) {
+	for {
+		interval, timeout := c.conn.PingParams()
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		if !c.IsAlive() {
+			return
+		}
+
+		// drop the pong which came too late for the previous ping
+		select {
+		case <-c.pongC:
+		default:
+		}
+
+		sentAt := time.Now()
+		c.outC <- protocol.MessagePing
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.pongC:
+			atomic.StoreInt64(&c.rtt, int64(time.Since(sentAt)))
+		case <-time.After(timeout):
+			logging.Log().Debug("Channel.pingLoop(): pong timed out")
+			c.close(e, ReasonPingTimeout)
+			return
+		}
+	}
+}
+
+// heartbeatLoop closes the channel if nothing was received from the remote side within ping interval
+// and ping timeout, the remote side is expected to ping each ping interval
+func (c *Channel) heartbeatLoop(e *event) {
+	for {
+		interval, timeout := c.conn.PingParams()
+		deadline := time.Unix(0, atomic.LoadInt64(&c.lastSeen)).Add(interval + timeout)
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(time.Until(deadline)):
+		}
+
+		if !c.IsAlive() {
+			return
+		}
+
+		lastSeen := time.Unix(0, atomic.LoadInt64(&c.lastSeen))
+		if time.Since(lastSeen) >= interval+timeout {
+			logging.Log().Debug("Channel.heartbeatLoop(): ping timed out")
+			c.close(e, ReasonPingTimeout)
+			return
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package gosocketio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+func TestServerChannelRTT(t *testing.T) {
+	const interval = 50 * time.Millisecond
+
+	s := NewServer()
+	s.websocket.PingInterval = interval
+	channelC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { channelC <- c })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	tr := transport.DefaultWebsocketTransport()
+	tr.PingInterval = interval
+	c, err := Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+"/socket.io/?EIO=3&transport=websocket", tr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var serverChannel *Channel
+	select {
+	case serverChannel = <-channelC:
+	case <-time.After(time.Second):
+		t.Fatal("no connection")
+	}
+
+	// only measuring is checked, scheduling delays on a loaded machine may exceed the interval
+	deadline := time.Now().Add(100 * interval)
+	for serverChannel.RTT() <= 0 || c.RTT() <= 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("RTT is not measured: server channel %v, client %v", serverChannel.RTT(), c.RTT())
+		}
+		time.Sleep(interval)
+	}
+}
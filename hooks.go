@@ -0,0 +1,87 @@
+package gosocketio
+
+import (
+	"sync"
+)
+
+// RoomHandler is a room lifecycle handler function
+type RoomHandler func(room string)
+
+// MembershipHandler is a room membership handler function
+type MembershipHandler func(c *Channel, room string)
+
+// roomHooks holds room lifecycle and membership handlers
+type roomHooks struct {
+	onRoomCreate []RoomHandler
+	onRoomDelete []RoomHandler
+	onJoin       []MembershipHandler
+	onLeave      []MembershipHandler
+	mu           sync.RWMutex
+}
+
+// OnRoomCreate registers f to be called when the first channel joins a room
+func (s *Server) OnRoomCreate(f RoomHandler) {
+	s.hooks.mu.Lock()
+	s.hooks.onRoomCreate = append(s.hooks.onRoomCreate, f)
+	s.hooks.mu.Unlock()
+}
+
+// OnRoomDelete registers f to be called when the last channel leaves a room
+func (s *Server) OnRoomDelete(f RoomHandler) {
+	s.hooks.mu.Lock()
+	s.hooks.onRoomDelete = append(s.hooks.onRoomDelete, f)
+	s.hooks.mu.Unlock()
+}
+
+// OnJoin registers f to be called when a channel joins a room
+func (s *Server) OnJoin(f MembershipHandler) {
+	s.hooks.mu.Lock()
+	s.hooks.onJoin = append(s.hooks.onJoin, f)
+	s.hooks.mu.Unlock()
+}
+
+// OnLeave registers f to be called when a channel leaves a room, including leaving at disconnection
+func (s *Server) OnLeave(f MembershipHandler) {
+	s.hooks.mu.Lock()
+	s.hooks.onLeave = append(s.hooks.onLeave, f)
+	s.hooks.mu.Unlock()
+}
+
+// joined fires hooks after channel c joined the room
+func (h *roomHooks) joined(c *Channel, room string, created bool) {
+	h.mu.RLock()
+	onRoomCreate, onJoin := h.onRoomCreate, h.onJoin
+	h.mu.RUnlock()
+
+	if created {
+		for _, f := range onRoomCreate {
+			callHook(c, func() { f(room) })
+		}
+	}
+	for _, f := range onJoin {
+		callHook(c, func() { f(c, room) })
+	}
+}
+
+// left fires hooks after channel c left the room
+func (h *roomHooks) left(c *Channel, room string, deleted bool) {
+	h.mu.RLock()
+	onLeave, onRoomDelete := h.onLeave, h.onRoomDelete
+	h.mu.RUnlock()
+
+	for _, f := range onLeave {
+		callHook(c, func() { f(c, room) })
+	}
+	if deleted {
+		for _, f := range onRoomDelete {
+			callHook(c, func() { f(room) })
+		}
+	}
+}
+
+// callHook calls hook f fired by channel c, a panic is recovered and reported to OnError handlers,
+// so the rest of hooks and the disconnection cleanup still run
+func callHook(c *Channel, f func()) {
+	defer c.events.recoverHandler(c, "")
+	f()
+}
@@ -0,0 +1,165 @@
+package gosocketio
+
+import (
+	"errors"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// connectTestClient starts a test server for s and connects a websocket client to it,
+// the server side channel of the client is returned
+func connectTestClient(t *testing.T, s *Server) (*httptest.Server, *Client, *Channel) {
+	t.Helper()
+	channelC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { channelC <- c })
+	ts := httptest.NewServer(s)
+
+	client, err := Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+"/socket.io/?EIO=3&transport=websocket",
+		transport.DefaultWebsocketTransport())
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	select {
+	case c := <-channelC:
+		return ts, client, c
+	case <-time.After(time.Second):
+		client.Close()
+		ts.Close()
+		t.Fatal("no connection")
+	}
+	return nil, nil, nil
+}
+
+// hookRecorder records fired room hooks
+type hookRecorder struct {
+	calls []string
+	mu    sync.Mutex
+}
+
+func (r *hookRecorder) add(call string) {
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+}
+
+func (r *hookRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.calls...)
+}
+
+// record registers room hooks of s recording their calls
+func (r *hookRecorder) record(s *Server) {
+	s.OnRoomCreate(func(room string) { r.add("create " + room) })
+	s.OnRoomDelete(func(room string) { r.add("delete " + room) })
+	s.OnJoin(func(c *Channel, room string) { r.add("join " + room) })
+	s.OnLeave(func(c *Channel, room string) { r.add("leave " + room) })
+}
+
+func TestRoomHooks(t *testing.T) {
+	s := NewServer()
+	var r hookRecorder
+	r.record(s)
+	ts, client, c := connectTestClient(t, s)
+	defer ts.Close()
+	defer client.Close()
+
+	c.Join("a")
+	c.Join("a")
+	c.Join("b")
+	c.Leave("a")
+	c.Leave("a")
+	want := []string{"create a", "join a", "create b", "join b", "leave a", "delete a"}
+	if calls := r.get(); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("hooks are called as %v, expected %v", calls, want)
+	}
+
+	disconnectedC := make(chan struct{})
+	s.On(OnDisconnection, func(c *Channel) { close(disconnectedC) })
+	client.Close()
+	<-disconnectedC
+	want = append(want, "leave b", "delete b")
+	if calls := r.get(); !reflect.DeepEqual(calls, want) {
+		t.Fatalf("hooks are called as %v at disconnection, expected %v", calls, want)
+	}
+}
+
+func TestRoomHookPanic(t *testing.T) {
+	s := NewServer()
+	var r hookRecorder
+	s.OnLeave(func(c *Channel, room string) { panic("leave") })
+	s.OnRoomDelete(func(room string) { panic("delete") })
+	r.record(s)
+
+	var panics int
+	s.On(OnError, func(c *Channel, err *ChannelError) {
+		if errors.Is(err, ErrorHandlerPanic) {
+			r.mu.Lock()
+			panics++
+			r.mu.Unlock()
+		}
+	})
+	disconnectedC := make(chan struct{})
+	s.On(OnDisconnection, func(c *Channel) { close(disconnectedC) })
+
+	ts, client, c := connectTestClient(t, s)
+	defer ts.Close()
+	c.Join("a")
+	client.Close()
+
+	select {
+	case <-disconnectedC:
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnection handler is not called")
+	}
+	if want := []string{"create a", "join a", "leave a", "delete a"}; !reflect.DeepEqual(r.get(), want) {
+		t.Fatalf("hooks are called as %v, expected %v", r.get(), want)
+	}
+	if _, err := s.GetChannel(c.Id()); err == nil {
+		t.Fatal("disconnected channel is still registered")
+	}
+	if s.CountRooms() != 0 {
+		t.Fatalf("%d rooms after disconnection", s.CountRooms())
+	}
+	s.limiter.mu.Lock()
+	total := s.limiter.total
+	s.limiter.mu.Unlock()
+	if total != 0 {
+		t.Fatalf("%d connections are counted by the limiter", total)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if panics != 2 {
+		t.Fatalf("%d hook panics are reported, expected 2", panics)
+	}
+}
+
+func TestJoinClosedChannel(t *testing.T) {
+	s := NewServer()
+	var r hookRecorder
+	r.record(s)
+	disconnectedC := make(chan struct{})
+	s.On(OnDisconnection, func(c *Channel) { close(disconnectedC) })
+
+	ts, client, c := connectTestClient(t, s)
+	defer ts.Close()
+	client.Close()
+	<-disconnectedC
+
+	if err := c.Join("a"); err != ErrorChannelClosed {
+		t.Fatalf("joining a closed channel returns %v, expected %v", err, ErrorChannelClosed)
+	}
+	if s.Amount("a") != 0 || s.CountRooms() != 0 {
+		t.Fatalf("closed channel is joined: %d channels in %d rooms", s.Amount("a"), s.CountRooms())
+	}
+	if calls := r.get(); len(calls) != 0 {
+		t.Fatalf("hooks are called as %v", calls)
+	}
+}
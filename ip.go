@@ -0,0 +1,130 @@
+package gosocketio
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// headerForwarded is RFC 7239 forwarding header
+const headerForwarded = "Forwarded"
+
+// ForwardingHeader is a header the trusted proxies maintain to report the client IP
+type ForwardingHeader int
+
+const (
+	// ForwardingHeaderXForwardedFor is X-Forwarded-For appended by most proxies like nginx, it's the default
+	ForwardingHeaderXForwardedFor ForwardingHeader = iota
+	// ForwardingHeaderForwarded is RFC 7239 Forwarded header
+	ForwardingHeaderForwarded
+)
+
+// SetTrustedProxies sets CIDRs like "10.0.0.0/8" of proxies trusted to report the client IP in the header
+// chosen by Server.ForwardingHeader, a single IP is treated as a host CIDR. It should be set before serving
+func (s *Server) SetTrustedProxies(cidrs ...string) error {
+	proxies := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(len(ip)*8, len(ip)*8)})
+			continue
+		}
+
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		proxies = append(proxies, ipNet)
+	}
+
+	s.trustedProxies = proxies
+	return nil
+}
+
+// clientIP returns an IP of the client making the request r
+func (s *Server) clientIP(r *http.Request) string {
+	return resolveIP(r.RemoteAddr, r.Header, s.trustedProxies, s.ForwardingHeader)
+}
+
+// resolveIP returns the client IP: the remote address without port if it's not a trusted proxy,
+// otherwise the first untrusted hop of the forwarding header walking it from the right. The other
+// forwarding header is ignored, as the proxies pass it from the client untouched
+func resolveIP(address string, header http.Header, trusted []*net.IPNet, forwarding ForwardingHeader) string {
+	ip := stripPort(address)
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+
+	hops := forwardedForHops(header)
+	if forwarding == ForwardingHeaderForwarded {
+		hops = forwardedHops(header)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := stripPort(hops[i])
+		if net.ParseIP(hop) == nil { // obfuscated or malformed hop, the last trusted one is the best known
+			return ip
+		}
+
+		ip = hop
+		if !isTrusted(ip, trusted) {
+			return ip
+		}
+	}
+	return ip
+}
+
+// forwardedHops returns hops of RFC 7239 Forwarded header in order
+func forwardedHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header[http.CanonicalHeaderKey(headerForwarded)] {
+		for _, element := range strings.Split(value, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				pair = strings.TrimSpace(pair)
+				if len(pair) > 4 && strings.EqualFold(pair[:4], "for=") {
+					hops = append(hops, strings.Trim(pair[4:], `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// forwardedForHops returns hops of X-Forwarded-For header in order
+func forwardedForHops(header http.Header) []string {
+	var hops []string
+	for _, value := range header[http.CanonicalHeaderKey(headerForward)] {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// stripPort returns the host of address "host:port", IPv6 brackets are removed
+func stripPort(address string) string {
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+}
+
+// isTrusted returns true if ip belongs to one of trusted networks
+func isTrusted(ip string, trusted []*net.IPNet) bool {
+	if len(trusted) == 0 {
+		return false
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, ipNet := range trusted {
+		if ipNet.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package gosocketio
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestSetTrustedProxies(t *testing.T) {
+	s := NewServer()
+	if err := s.SetTrustedProxies("10.0.0.0/8", "192.168.1.1", "::1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.trustedProxies) != 3 {
+		t.Fatalf("%d trusted proxies, expected 3", len(s.trustedProxies))
+	}
+	for _, cidr := range []string{"proxy", "10.0.0.0/33"} {
+		if err := s.SetTrustedProxies(cidr); err == nil {
+			t.Fatalf("malformed %q is accepted", cidr)
+		}
+	}
+}
+
+func TestResolveIP(t *testing.T) {
+	s := NewServer()
+	if err := s.SetTrustedProxies("10.0.0.0/8", "192.168.1.1", "::1", "2001:db8:ffff::/48"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		address    string
+		header     http.Header
+		forwarding ForwardingHeader
+		want       string
+	}{
+		{"no proxy", "203.0.113.7:5000", nil, ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"untrusted remote", "203.0.113.7:5000", http.Header{"X-Forwarded-For": {"6.6.6.6"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"untrusted remote forwarded", "203.0.113.7:5000", http.Header{"Forwarded": {"for=6.6.6.6"}},
+			ForwardingHeaderForwarded, "203.0.113.7"},
+		{"trusted without header", "10.0.0.1:5000", nil, ForwardingHeaderXForwardedFor, "10.0.0.1"},
+		{"spoofed forwarded", "10.0.0.1:5000",
+			http.Header{"Forwarded": {"for=6.6.6.6"}, "X-Forwarded-For": {"203.0.113.7"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"spoofed forwarded without xff", "10.0.0.1:5000", http.Header{"Forwarded": {"for=6.6.6.6"}},
+			ForwardingHeaderXForwardedFor, "10.0.0.1"},
+		{"spoofed xff", "10.0.0.1:5000",
+			http.Header{"Forwarded": {"for=203.0.113.7"}, "X-Forwarded-For": {"6.6.6.6"}},
+			ForwardingHeaderForwarded, "203.0.113.7"},
+		{"spoofed chain start", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"6.6.6.6, 203.0.113.7"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"chain of trusted", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"6.6.6.6, 203.0.113.7, 192.168.1.1, 10.1.1.1"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"chain in several headers", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"6.6.6.6, 203.0.113.7", "10.1.1.1"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"all trusted", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"10.2.2.2, 10.1.1.1"}},
+			ForwardingHeaderXForwardedFor, "10.2.2.2"},
+		{"forwarded chain", "10.0.0.1:5000",
+			http.Header{"Forwarded": {"for=6.6.6.6;proto=http, for=203.0.113.7", `for="10.1.1.1";by=10.0.0.1`}},
+			ForwardingHeaderForwarded, "203.0.113.7"},
+		{"forwarded case", "10.0.0.1:5000", http.Header{"Forwarded": {"For=203.0.113.7"}},
+			ForwardingHeaderForwarded, "203.0.113.7"},
+		{"ipv6 remote", "[::1]:5000", http.Header{"X-Forwarded-For": {"2001:db8::7"}},
+			ForwardingHeaderXForwardedFor, "2001:db8::7"},
+		{"ipv6 untrusted remote", "[2001:db8::7]:5000", nil, ForwardingHeaderXForwardedFor, "2001:db8::7"},
+		{"ipv6 with port", "[::1]:5000", http.Header{"X-Forwarded-For": {"[2001:db8::7]:4711"}},
+			ForwardingHeaderXForwardedFor, "2001:db8::7"},
+		{"ipv6 forwarded with brackets and port", "[::1]:5000",
+			http.Header{"Forwarded": {`for="[2001:db8::7]:4711", for="[2001:db8:ffff::1]"`}},
+			ForwardingHeaderForwarded, "2001:db8::7"},
+		{"ipv4 with port", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7:4711"}},
+			ForwardingHeaderXForwardedFor, "203.0.113.7"},
+		{"unknown hop", "10.0.0.1:5000", http.Header{"Forwarded": {"for=203.0.113.7, for=unknown, for=10.1.1.1"}},
+			ForwardingHeaderForwarded, "10.1.1.1"},
+		{"obfuscated hop", "10.0.0.1:5000", http.Header{"Forwarded": {"for=203.0.113.7, for=_hidden"}},
+			ForwardingHeaderForwarded, "10.0.0.1"},
+		{"malformed xff hop", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7, not-an-ip"}},
+			ForwardingHeaderXForwardedFor, "10.0.0.1"},
+		{"empty xff hop", "10.0.0.1:5000", http.Header{"X-Forwarded-For": {"203.0.113.7, "}},
+			ForwardingHeaderXForwardedFor, "10.0.0.1"},
+	} {
+		if got := resolveIP(tc.address, tc.header, s.trustedProxies, tc.forwarding); got != tc.want {
+			t.Errorf("%s: resolved %q, expected %q", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gosocketio
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+var (
+	ErrorTooManyConnections      = errors.New("too many connections")
+	ErrorTooManyConnectionsPerIP = errors.New("too many connections from the IP")
+	ErrorHandshakeRateExceeded   = errors.New("handshake rate exceeded")
+)
+
+// Limits restricts new connections accepted by the server, zero values mean no limit
+type Limits struct {
+	MaxConnections      int     // maximum amount of connections, exceeding is answered with 503
+	MaxConnectionsPerIP int     // maximum amount of connections from an IP, exceeding is answered with 429
+	HandshakeRate       float64 // handshakes per second allowed from an IP, exceeding is answered with 429
+	HandshakeBurst      int     // handshakes allowed from an IP at once, 1 is used if it's zero
+}
+
+// connectionLimiter counts connections globally and per IP
+type connectionLimiter struct {
+	total      int
+	perIP      map[string]int
+	handshakes *rateLimiter
+	mu         sync.Mutex
+}
+
+// newConnectionLimiter returns an initialized connection limiter
+func newConnectionLimiter() *connectionLimiter {
+	return &connectionLimiter{perIP: make(map[string]int), handshakes: newRateLimiter()}
+}
+
+// acquire a connection from the ip within limits l, it should be released when the connection is closed
+func (cl *connectionLimiter) acquire(ip string, l Limits) error {
+	if l.HandshakeRate > 0 {
+		burst := l.HandshakeBurst
+		if burst < 1 {
+			burst = 1
+		}
+		if !cl.handshakes.allow(ip, l.HandshakeRate, burst) {
+			return ErrorHandshakeRateExceeded
+		}
+	}
+
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if l.MaxConnections > 0 && cl.total >= l.MaxConnections {
+		return ErrorTooManyConnections
+	}
+	if l.MaxConnectionsPerIP > 0 && cl.perIP[ip] >= l.MaxConnectionsPerIP {
+		return ErrorTooManyConnectionsPerIP
+	}
+
+	cl.total++
+	cl.perIP[ip]++
+	return nil
+}
+
+// release a connection from the ip
+func (cl *connectionLimiter) release(ip string) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	cl.total--
+	if cl.perIP[ip]--; cl.perIP[ip] <= 0 {
+		delete(cl.perIP, ip)
+	}
+}
+
+// writeLimitError responds to the request rejected by the connection limits with the Engine.IO forbidden error
+func writeLimitError(w http.ResponseWriter, err error) {
+	status := http.StatusTooManyRequests
+	if err == ErrorTooManyConnections {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Retry-After", "1")
+	transport.WriteError(w, &transport.ProtocolError{Code: transport.ErrorForbidden.Code, Message: err.Error(), Status: status})
+}
@@ -0,0 +1,122 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// newLimitedServer returns a test server with limits l and its websocket address
+func newLimitedServer(l Limits) (*Server, *httptest.Server, string) {
+	s := NewServer()
+	s.Limits = l
+	ts := httptest.NewServer(s)
+	return s, ts, strings.Replace(ts.URL, "http://", "ws://", 1) + "/socket.io/?EIO=3&transport=websocket"
+}
+
+// waitNoChannels waits until all channels of s are closed
+func waitNoChannels(t *testing.T, s *Server) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for s.CountChannels() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d channels are not closed", s.CountChannels())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// checkLimitResponse checks that the polling handshake to ts is rejected with status and limit error err
+func checkLimitResponse(t *testing.T, ts *httptest.Server, status int, err error) {
+	t.Helper()
+	resp, rErr := http.Get(ts.URL + "/socket.io/?EIO=3&transport=polling")
+	if rErr != nil {
+		t.Fatal(rErr)
+	}
+	defer resp.Body.Close()
+
+	var e transport.ProtocolError
+	if dErr := json.NewDecoder(resp.Body).Decode(&e); dErr != nil {
+		t.Fatalf("response %s is not an Engine.IO error: %v", resp.Status, dErr)
+	}
+	if resp.StatusCode != status || resp.Header.Get("Retry-After") != "1" ||
+		e.Code != transport.ErrorForbidden.Code || e.Message != err.Error() {
+		t.Fatalf("response is %s %+v, expected %d %q", resp.Status, e, status, err)
+	}
+}
+
+// checkLimitDialError checks that dialing addr is rejected with status and limit error err
+func checkLimitDialError(t *testing.T, addr string, status int, err error) {
+	t.Helper()
+	c, dErr := Dial(addr, transport.DefaultWebsocketTransport())
+	if dErr == nil {
+		c.Close()
+		t.Fatal("connection exceeding the limits is accepted")
+	}
+	var e *transport.ProtocolError
+	if !errors.As(dErr, &e) || !errors.Is(dErr, transport.ErrorForbidden) || e.Status != status || e.Message != err.Error() {
+		t.Fatalf("dial error is %v, expected %d %q", dErr, status, err)
+	}
+}
+
+func TestConnectionsPerIPLimit(t *testing.T) {
+	s, ts, addr := newLimitedServer(Limits{MaxConnectionsPerIP: 1})
+	defer ts.Close()
+
+	c, err := Dial(addr, transport.DefaultWebsocketTransport())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLimitResponse(t, ts, http.StatusTooManyRequests, ErrorTooManyConnectionsPerIP)
+	checkLimitDialError(t, addr, http.StatusTooManyRequests, ErrorTooManyConnectionsPerIP)
+
+	c.Close()
+	waitNoChannels(t, s)
+	if c, err = Dial(addr, transport.DefaultWebsocketTransport()); err != nil {
+		t.Fatalf("connection is rejected after the previous one is closed: %v", err)
+	}
+	c.Close()
+	waitNoChannels(t, s)
+}
+
+func TestMaxConnectionsLimit(t *testing.T) {
+	s, ts, addr := newLimitedServer(Limits{MaxConnections: 2, MaxConnectionsPerIP: 3})
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := Dial(addr, transport.DefaultWebsocketTransport())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+	}
+	checkLimitResponse(t, ts, http.StatusServiceUnavailable, ErrorTooManyConnections)
+	checkLimitDialError(t, addr, http.StatusServiceUnavailable, ErrorTooManyConnections)
+	if n := s.CountChannels(); n != 2 {
+		t.Fatalf("%d channels, expected 2", n)
+	}
+}
+
+func TestHandshakeRateLimit(t *testing.T) {
+	s, ts, addr := newLimitedServer(Limits{HandshakeRate: 0.001, HandshakeBurst: 2})
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		c, err := Dial(addr, transport.DefaultWebsocketTransport())
+		if err != nil {
+			t.Fatalf("handshake %d within the burst is rejected: %v", i, err)
+		}
+		c.Close()
+	}
+	waitNoChannels(t, s)
+
+	// closed connections don't restore the rate
+	checkLimitResponse(t, ts, http.StatusTooManyRequests, ErrorHandshakeRateExceeded)
+	checkLimitDialError(t, addr, http.StatusTooManyRequests, ErrorHandshakeRateExceeded)
+}
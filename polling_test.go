@@ -0,0 +1,97 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pollingGet sends polling GET request of the session sid to the server at url with client, returns the body
+func pollingGet(t *testing.T, client *http.Client, url, sid string) string {
+	t.Helper()
+	query := "/socket.io/?EIO=3&transport=polling"
+	if sid != "" {
+		query += "&sid=" + sid
+	}
+	resp, err := client.Get(url + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// pollingHandshake opens a polling session at the server at url with client, returns the session id
+func pollingHandshake(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+	// the body starts with the length prefixed open packet
+	body := pollingGet(t, client, url, "")
+	var header connectionHeader
+	if err := json.NewDecoder(strings.NewReader(body[strings.Index(body, ":")+2:])).Decode(&header); err != nil {
+		t.Fatalf("handshake response %q: %v", body, err)
+	}
+	return header.Sid
+}
+
+func TestAbandonedPollingSession(t *testing.T) {
+	const interval, timeout = 50 * time.Millisecond, 50 * time.Millisecond
+
+	s := NewServer()
+	s.polling.PingInterval = interval
+	s.polling.PingTimeout = timeout
+	disconnectedC := make(chan DisconnectReason, 1)
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { disconnectedC <- reason })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	baseline := runtime.NumGoroutine()
+
+	sid := pollingHandshake(t, client, ts.URL)
+	resp, err := client.Post(ts.URL+"/socket.io/?EIO=3&transport=polling&sid="+sid, "text/plain",
+		strings.NewReader(`11:42["ev",1]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if n := s.CountPollingSessions(); n != 1 {
+		t.Fatalf("%d polling sessions, expected 1", n)
+	}
+
+	// the session is abandoned
+	client.CloseIdleConnections()
+	select {
+	case reason := <-disconnectedC:
+		if reason != ReasonPingTimeout {
+			t.Fatalf("disconnect reason is %v, expected %v", reason, ReasonPingTimeout)
+		}
+	case <-time.After(10 * (interval + timeout)):
+		t.Fatal("abandoned session is not expired")
+	}
+
+	if n := s.CountPollingSessions(); n != 0 {
+		t.Fatalf("%d polling sessions, expected 0", n)
+	}
+	if n := s.CountChannels(); n != 0 {
+		t.Fatalf("%d channels, expected 0", n)
+	}
+
+	deadline := time.Now().Add(10 * (interval + timeout))
+	for runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("%d goroutines, expected %d:\n%s", runtime.NumGoroutine(), baseline,
+				buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(interval)
+	}
+}
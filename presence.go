@@ -0,0 +1,176 @@
+package gosocketio
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	EventPresenceJoin  = "presence:join"
+	EventPresenceLeave = "presence:leave"
+	EventPresenceSync  = "presence:sync"
+
+	DefaultPresenceDebounce = 5 * time.Second
+)
+
+// PresenceUser represents a user present in a room
+type PresenceUser struct {
+	ID   string      `json:"id"`
+	Meta interface{} `json:"meta,omitempty"`
+}
+
+// Presence is a payload of presence events: joined or left users for presence:join and presence:leave,
+// and all present users for presence:sync
+type Presence struct {
+	Room  string         `json:"room"`
+	Users []PresenceUser `json:"users"`
+}
+
+// presenceEntry represents a user present in a room with all of it's channels
+type presenceEntry struct {
+	meta     interface{}
+	channels map[*Channel]struct{}
+	leaveT   *time.Timer // pending leave, fires after debounce interval
+}
+
+// presenceShard is a part of presence tracker guarded by it's own lock
+type presenceShard struct {
+	sync.Mutex
+	users map[string]map[string]*presenceEntry // maps room name to map of user ids to entries
+}
+
+// presenceTracker tracks users present in the rooms, merging the channels of the same user
+type presenceTracker struct {
+	server *Server
+	shards [registryShardsCount]presenceShard
+}
+
+// newPresenceTracker returns an initialized presence tracker for server s
+func newPresenceTracker(s *Server) *presenceTracker {
+	t := &presenceTracker{server: s}
+	for i := range t.shards {
+		t.shards[i].users = make(map[string]map[string]*presenceEntry)
+	}
+	return t
+}
+
+// shard returns a shard holding the given room
+func (t *presenceTracker) shard(room string) *presenceShard { return &t.shards[shardIndex(room)] }
+
+// join channel c of user with id uid to the room presence
+func (t *presenceTracker) join(c *Channel, uid string, meta interface{}, room string) {
+	shard := t.shard(room)
+	shard.Lock()
+	if _, ok := shard.users[room]; !ok {
+		shard.users[room] = make(map[string]*presenceEntry)
+	}
+
+	entry, exists := shard.users[room][uid]
+	if !exists {
+		entry = &presenceEntry{channels: make(map[*Channel]struct{})}
+		shard.users[room][uid] = entry
+	}
+	if entry.leaveT != nil { // reconnected before the leave was announced
+		entry.leaveT.Stop()
+		entry.leaveT = nil
+	}
+	entry.meta = meta
+	entry.channels[c] = struct{}{}
+	users := shard.list(room)
+	shard.Unlock()
+
+	if !exists {
+		t.server.BroadcastTo(room, EventPresenceJoin, Presence{Room: room, Users: []PresenceUser{{uid, meta}}})
+	}
+	c.Emit(EventPresenceSync, Presence{Room: room, Users: users})
+}
+
+// leave removes channel c of user with id uid from the room presence, the leave is announced
+// after the server presence debounce interval if the user has no more channels in the room
+func (t *presenceTracker) leave(c *Channel, uid string, room string) {
+	shard := t.shard(room)
+	shard.Lock()
+	entry, ok := shard.users[room][uid]
+	if !ok {
+		shard.Unlock()
+		return
+	}
+
+	delete(entry.channels, c)
+	if len(entry.channels) > 0 || entry.leaveT != nil {
+		shard.Unlock()
+		return
+	}
+
+	if t.server.PresenceDebounce > 0 {
+		entry.leaveT = time.AfterFunc(t.server.PresenceDebounce, func() { t.expire(room, uid, entry) })
+		shard.Unlock()
+		return
+	}
+
+	shard.remove(room, uid)
+	shard.Unlock()
+
+	t.server.BroadcastTo(room, EventPresenceLeave, Presence{Room: room, Users: []PresenceUser{{uid, entry.meta}}})
+}
+
+// expire announces the leave of user with id uid from the room if it is still pending for the entry
+func (t *presenceTracker) expire(room, uid string, entry *presenceEntry) {
+	shard := t.shard(room)
+	shard.Lock()
+	if shard.users[room][uid] != entry || len(entry.channels) > 0 {
+		shard.Unlock()
+		return
+	}
+	shard.remove(room, uid)
+	shard.Unlock()
+
+	t.server.BroadcastTo(room, EventPresenceLeave, Presence{Room: room, Users: []PresenceUser{{uid, entry.meta}}})
+}
+
+// replace channel old with channel c of user with id uid in the room presence
+func (t *presenceTracker) replace(room, uid string, old, c *Channel) {
+	shard := t.shard(room)
+	shard.Lock()
+	defer shard.Unlock()
+
+	entry, ok := shard.users[room][uid]
+	if !ok {
+		return
+	}
+
+	if _, ok := entry.channels[old]; ok {
+		delete(entry.channels, old)
+		entry.channels[c] = struct{}{}
+	}
+}
+
+// list returns users present in the room
+func (t *presenceTracker) list(room string) []PresenceUser {
+	shard := t.shard(room)
+	shard.Lock()
+	defer shard.Unlock()
+	return shard.list(room)
+}
+
+// list returns users present in the room sorted by id, should be called with shard locked
+func (s *presenceShard) list(room string) []PresenceUser {
+	users := make([]PresenceUser, 0, len(s.users[room]))
+	for uid, entry := range s.users[room] {
+		users = append(users, PresenceUser{ID: uid, Meta: entry.meta})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+// remove user with id uid from the room, should be called with shard locked
+func (s *presenceShard) remove(room, uid string) {
+	delete(s.users[room], uid)
+	if len(s.users[room]) == 0 {
+		delete(s.users, room)
+	}
+}
+
+// Presence returns a list of users present in the given room
+func (s *Server) Presence(room string) []PresenceUser { return s.presence.list(room) }
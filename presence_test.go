@@ -0,0 +1,101 @@
+package gosocketio
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/transport"
+)
+
+// presenceIDs returns ids of users present in the room of s
+func presenceIDs(s *Server, room string) []string {
+	var ids []string
+	for _, u := range s.Presence(room) {
+		ids = append(ids, u.ID)
+	}
+	return ids
+}
+
+func TestPresenceMergeAndDebounce(t *testing.T) {
+	const debounce = 500 * time.Millisecond
+
+	s := NewServer()
+	s.PresenceDebounce = debounce
+	channelC := make(chan *Channel, 1)
+	s.On(OnConnection, func(c *Channel) { channelC <- c })
+	disconnectedC := make(chan *Channel, 1)
+	s.On(OnDisconnection, func(c *Channel) { disconnectedC <- c })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	// connect returns a client joined to the room as the user with the given id
+	connect := func(id string) *Client {
+		client, err := Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+"/socket.io/?EIO=3&transport=websocket",
+			transport.DefaultWebsocketTransport())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := <-channelC
+		c.SetUser(id, nil)
+		if err := c.Join("room"); err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	// disconnect closes the client and waits for the server to close its channel
+	disconnect := func(client *Client) time.Time {
+		client.Close()
+		select {
+		case <-disconnectedC:
+		case <-time.After(time.Second):
+			t.Fatal("client is not disconnected")
+		}
+		return time.Now()
+	}
+
+	observer := connect("observer")
+	defer observer.Close()
+	leftC := make(chan Presence, 2)
+	observer.On(EventPresenceLeave, func(c *Channel, p Presence) { leftC <- p })
+
+	// channels of the same user are merged
+	first, second := connect("user"), connect("user")
+	if ids := presenceIDs(s, "room"); strings.Join(ids, ",") != "observer,user" {
+		t.Fatalf("present users are %v", ids)
+	}
+	disconnect(first)
+	if ids := presenceIDs(s, "room"); strings.Join(ids, ",") != "observer,user" {
+		t.Fatalf("present users are %v after one of user channels left", ids)
+	}
+
+	// a reconnect within the debounce interval isn't announced
+	disconnect(second)
+	reconnected := connect("user")
+	time.Sleep(2 * debounce)
+	if ids := presenceIDs(s, "room"); strings.Join(ids, ",") != "observer,user" {
+		t.Fatalf("present users are %v after reconnect", ids)
+	}
+
+	left := disconnect(reconnected)
+	select {
+	case p := <-leftC:
+		if elapsed := time.Since(left); elapsed < debounce {
+			t.Fatalf("leave is announced in %v, before the debounce interval", elapsed)
+		}
+		if p.Room != "room" || len(p.Users) != 1 || p.Users[0].ID != "user" {
+			t.Fatalf("leave is announced as %+v", p)
+		}
+	case <-time.After(10 * debounce):
+		t.Fatal("leave is not announced")
+	}
+	if ids := presenceIDs(s, "room"); strings.Join(ids, ",") != "observer" {
+		t.Fatalf("present users are %v after leave", ids)
+	}
+	select {
+	case p := <-leftC:
+		t.Fatalf("merged or reconnected channel leave is announced: %+v", p)
+	default:
+	}
+}
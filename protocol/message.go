@@ -11,6 +11,7 @@ const (
 	MessageTypeAckResponse        // ack response
 	MessageTypeUpgrade            // upgrade message
 	MessageTypeBlank              // blank message
+	MessageTypeDisconnect         // namespace disconnect message
 )
 
 // Message represents socket.io message
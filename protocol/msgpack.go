@@ -0,0 +1,772 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// BinaryMessagePrefix is the engine.io message type prefix of binary packets
+const BinaryMessagePrefix = "\x04"
+
+// socket.io packet types used by socket.io-msgpack-parser
+const (
+	packetConnect = iota
+	packetDisconnect
+	packetEvent
+	packetAck
+	packetError
+	packetBinaryEvent
+	packetBinaryAck
+)
+
+var ErrorMsgpackUnsupported = errors.New("unsupported msgpack type")
+
+// MsgpackParser is a binary parser compatible with socket.io-msgpack-parser 2.x: socket.io packets are sent
+// as msgpack encoded binary engine.io messages, engine.io packets are sent as text. Connect and disconnect
+// packets sent by the JS parser as JSON text are accepted too. Payloads are marshaled to msgpack,
+// struct fields are named as with encoding/json
+type MsgpackParser struct{}
+
+// Marshal returns msgpack encoding of v
+func (MsgpackParser) Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := writeMsgpackValue(buf, reflect.ValueOf(v), 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal msgpack data into v, integers unmarshaled into an interface value are int64 or uint64
+func (MsgpackParser) Unmarshal(data []byte, v interface{}) error {
+	r := &msgpackReader{data: data}
+	value, err := r.read()
+	if err != nil {
+		return err
+	}
+	if r.pos != len(data) {
+		return ErrorWrongPacket
+	}
+
+	dst := reflect.ValueOf(v)
+	if dst.Kind() != reflect.Ptr || dst.IsNil() {
+		return ErrorMsgpackUnsupported
+	}
+	return setMsgpackValue(dst.Elem(), value)
+}
+
+// Encode a socket.io message m to the binary packet
+func (MsgpackParser) Encode(m *Message) (string, error) {
+	var packetType int
+
+	switch m.Type {
+	case MessageTypeEmpty:
+		packetType = packetConnect
+	case MessageTypeDisconnect:
+		packetType = packetDisconnect
+	case MessageTypeEmit, MessageTypeAckRequest:
+		packetType = packetEvent
+	case MessageTypeAckResponse:
+		packetType = packetAck
+	default: // engine.io packets
+		return Encode(m)
+	}
+
+	args, err := msgpackCount(m.Args)
+	if err != nil {
+		return "", err
+	}
+	// connect packet data is a single value
+	if m.Type == MessageTypeEmpty && args > 1 {
+		return "", ErrorWrongPacket
+	}
+
+	withData := packetType == packetEvent || packetType == packetAck || args > 0
+	withID := m.Type == MessageTypeAckRequest || m.Type == MessageTypeAckResponse
+
+	buf := bytes.NewBufferString(BinaryMessagePrefix)
+	fields := 2
+	if withData {
+		fields++
+	}
+	if withID {
+		fields++
+	}
+
+	writeMsgpackLen(buf, fields, 0x80, 0xde)
+	writeMsgpack(buf, "type")
+	writeMsgpack(buf, int64(packetType))
+	if withData {
+		writeMsgpack(buf, "data")
+		switch packetType {
+		case packetEvent:
+			writeMsgpackLen(buf, args+1, 0x90, 0xdc)
+			writeMsgpack(buf, m.EventName)
+		case packetAck:
+			writeMsgpackLen(buf, args, 0x90, 0xdc)
+		}
+		buf.WriteString(m.Args)
+	}
+	writeMsgpack(buf, "nsp")
+	writeMsgpack(buf, "/")
+	if withID {
+		writeMsgpack(buf, "id")
+		writeMsgpack(buf, int64(m.AckID))
+	}
+
+	return buf.String(), nil
+}
+
+// Decode the given binary packet into a Message. Text engine.io packets are decoded as with JSONParser,
+// text socket.io packets are accepted in JSON format of socket.io-msgpack-parser only
+func (MsgpackParser) Decode(data string) (*Message, error) {
+	var (
+		packet *msgpackPacket
+		err    error
+	)
+
+	switch {
+	case strings.HasPrefix(data, BinaryMessagePrefix):
+		packet, err = readMsgpackPacket(data)
+	case strings.HasPrefix(data, messageMSG+"{"):
+		packet, err = readJSONPacket(data)
+	default:
+		m, err := Decode(data)
+		if err != nil {
+			return nil, err
+		}
+		switch m.Type {
+		case MessageTypeEmpty, MessageTypeDisconnect, MessageTypeEmit, MessageTypeAckRequest, MessageTypeAckResponse:
+			return nil, newPacketError(data, 1, ErrorWrongPacket, "expected msgpack parser packet")
+		}
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return packet.message(data)
+}
+
+// msgpackPacket is a decoded socket.io-msgpack-parser packet, it's data is kept msgpack encoded
+type msgpackPacket struct {
+	packetType, id int64
+	hasType, hasID bool
+	data           []byte // nil if the packet has no data
+}
+
+// readMsgpackPacket decodes the binary packet in data
+func readMsgpackPacket(data string) (*msgpackPacket, error) {
+	offset := len(BinaryMessagePrefix)
+	r := &msgpackReader{data: []byte(data[offset:])}
+	fail := func(err error, reason string) (*msgpackPacket, error) {
+		return nil, newPacketError(data, offset+r.pos, err, reason)
+	}
+
+	n, err := r.mapLen()
+	if err != nil {
+		return fail(ErrorWrongPacket, "expected msgpack map")
+	}
+
+	packet := &msgpackPacket{}
+	for i := 0; i < n; i++ {
+		key, err := r.read()
+		if err != nil {
+			return fail(err, "malformed msgpack")
+		}
+
+		switch key {
+		case "type", "id":
+			v, err := r.read()
+			if err != nil {
+				return fail(err, "malformed msgpack")
+			}
+			if key == "type" {
+				packet.packetType, packet.hasType = msgpackInt(v)
+			} else {
+				packet.id, packet.hasID = msgpackInt(v)
+			}
+		case "data":
+			start := r.pos
+			if err := r.skip(); err != nil {
+				return fail(err, "malformed msgpack")
+			}
+			packet.data = r.data[start:r.pos]
+		default:
+			if err := r.skip(); err != nil {
+				return fail(err, "malformed msgpack")
+			}
+		}
+	}
+
+	if r.pos != len(r.data) {
+		return fail(ErrorWrongPacket, "unexpected data after the packet")
+	}
+	return packet, nil
+}
+
+// readJSONPacket decodes the packet sent by socket.io-msgpack-parser as JSON text, like connect packets
+func readJSONPacket(data string) (*msgpackPacket, error) {
+	offset := len(messageMSG)
+	decoder := json.NewDecoder(strings.NewReader(data[offset:]))
+	decoder.UseNumber()
+
+	var fields map[string]interface{}
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, newPacketError(data, offset, ErrorWrongPacket, "malformed JSON object: "+err.Error())
+	}
+	if decoder.More() {
+		return nil, newPacketError(data, offset, ErrorWrongPacket, "unexpected data after the packet")
+	}
+
+	packet := &msgpackPacket{}
+	packet.packetType, packet.hasType = jsonInt(fields["type"])
+	packet.id, packet.hasID = jsonInt(fields["id"])
+	if v, ok := fields["data"]; ok {
+		buf := &bytes.Buffer{}
+		if err := writeMsgpack(buf, v); err != nil {
+			return nil, newPacketError(data, offset, ErrorWrongPacket, "unsupported value: "+err.Error())
+		}
+		packet.data = buf.Bytes()
+	}
+	return packet, nil
+}
+
+// message returns the packet read from data as a Message
+func (p *msgpackPacket) message(data string) (*Message, error) {
+	if !p.hasType {
+		return nil, newPacketError(data, 0, ErrorWrongMessageType, "missing packet type")
+	}
+
+	m := &Message{Source: data, AckID: int(p.id)}
+	r := &msgpackReader{data: p.data}
+	switch p.packetType {
+	case packetConnect:
+		m.Type = MessageTypeEmpty
+		if len(p.data) > 0 && p.data[0] != msgpackNil {
+			m.Args = string(p.data)
+		}
+		return m, nil
+	case packetDisconnect:
+		m.Type = MessageTypeDisconnect
+		return m, nil
+	case packetEvent, packetBinaryEvent:
+		m.Type = MessageTypeEmit
+		if p.hasID {
+			m.Type = MessageTypeAckRequest
+		}
+		n, err := r.arrayLen()
+		if err != nil || n == 0 {
+			return nil, newPacketError(data, 0, ErrorWrongPacket, "missing event name")
+		}
+		name, err := r.read()
+		if err != nil {
+			return nil, newPacketError(data, 0, ErrorWrongPacket, "malformed event name")
+		}
+		var ok bool
+		if m.EventName, ok = name.(string); !ok {
+			return nil, newPacketError(data, 0, ErrorWrongPacket, "event name is not a string")
+		}
+	case packetAck, packetBinaryAck:
+		if !p.hasID {
+			return nil, newPacketError(data, 0, ErrorWrongPacket, "missing ack id")
+		}
+		m.Type = MessageTypeAckResponse
+		if p.data == nil {
+			return m, nil
+		}
+		if _, err := r.arrayLen(); err != nil {
+			return nil, newPacketError(data, 0, ErrorWrongPacket, "expected data array")
+		}
+	default:
+		return nil, newPacketError(data, 0, ErrorWrongMessageType, "unsupported socket.io packet type")
+	}
+
+	// the arguments are the rest of the data array
+	m.Args = string(p.data[r.pos:])
+	return m, nil
+}
+
+// jsonInt returns v as an integer if it is a decoded JSON integer
+func jsonInt(v interface{}) (int64, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, false
+	}
+	i, err := n.Int64()
+	return i, err == nil
+}
+
+// msgpackCount returns an amount of consecutive msgpack values in args
+func msgpackCount(args string) (int, error) {
+	r := &msgpackReader{data: []byte(args)}
+	n := 0
+	for r.pos < len(r.data) {
+		if err := r.skip(); err != nil {
+			return 0, ErrorWrongPacket
+		}
+		n++
+	}
+	return n, nil
+}
+
+// msgpackInt returns v as an integer if it is a decoded msgpack integer
+func msgpackInt(v interface{}) (int64, bool) {
+	switch i := v.(type) {
+	case int64:
+		return i, true
+	case uint64:
+		return int64(i), true
+	}
+	return 0, false
+}
+
+// writeMsgpackLen writes a header of string, array or map of length n,
+// fix is the fixed format type byte and long is the 16-bit length format type byte
+func writeMsgpackLen(buf *bytes.Buffer, n int, fix, long byte) {
+	switch {
+	case fix == 0xa0 && n < 32, fix != 0xa0 && n < 16:
+		buf.WriteByte(fix | byte(n))
+	case fix == 0xa0 && n < 256:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n < 65536:
+		buf.WriteByte(long)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(long + 1)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// msgpackNil is the msgpack nil value
+const msgpackNil = 0xc0
+
+// writeMsgpack writes value v decoded from JSON or msgpack to the buffer in msgpack format
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(msgpackNil)
+	case bool:
+		writeMsgpackBool(buf, v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		writeMsgpackFloat(buf, f)
+	case int64:
+		writeMsgpackInt(buf, v)
+	case uint64:
+		writeMsgpackUint(buf, v)
+	case float64:
+		writeMsgpackFloat(buf, v)
+	case string:
+		writeMsgpackLen(buf, len(v), 0xa0, 0xda)
+		buf.WriteString(v)
+	case []byte:
+		writeMsgpackBin(buf, v)
+	case []interface{}:
+		writeMsgpackLen(buf, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		writeMsgpackLen(buf, len(v), 0x80, 0xde)
+		for _, key := range keys {
+			writeMsgpack(buf, key)
+			if err := writeMsgpack(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrorMsgpackUnsupported
+	}
+	return nil
+}
+
+// writeMsgpackBool writes boolean v to the buffer
+func writeMsgpackBool(buf *bytes.Buffer, v bool) {
+	if v {
+		buf.WriteByte(0xc3)
+	} else {
+		buf.WriteByte(0xc2)
+	}
+}
+
+// writeMsgpackInt writes integer v to the buffer in the shortest format
+func writeMsgpackInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v >= 0:
+		writeMsgpackUint(buf, uint64(v))
+	case v >= -32:
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(v))
+	case v >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(v))
+	case v >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(v))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// writeMsgpackUint writes unsigned integer v to the buffer in the shortest format
+func writeMsgpackUint(buf *bytes.Buffer, v uint64) {
+	switch {
+	case v < 128:
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(v))
+	case v <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(v))
+	case v <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(v))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, v)
+	}
+}
+
+// writeMsgpackFloat writes v to the buffer as float 64
+func writeMsgpackFloat(buf *bytes.Buffer, v float64) {
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, v)
+}
+
+// writeMsgpackBin writes binary data b to the buffer
+func writeMsgpackBin(buf *bytes.Buffer, b []byte) {
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.Write(b)
+}
+
+// maxMsgpackDepth limits nesting of decoded msgpack arrays and maps and of marshaled Go values
+const maxMsgpackDepth = 1000
+
+// msgpackReader decodes msgpack data
+type msgpackReader struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+// nest enters a nested array or map, leave should be deferred after it succeeds
+func (r *msgpackReader) nest() error {
+	if r.depth == maxMsgpackDepth {
+		return ErrorWrongPacket
+	}
+	r.depth++
+	return nil
+}
+
+// leave a nested array or map
+func (r *msgpackReader) leave() { r.depth-- }
+
+// next returns n following bytes
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data)-r.pos {
+		return nil, ErrorWrongPacket
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint reads n bytes long big endian unsigned integer
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range b {
+		u = u<<8 | uint64(c)
+	}
+	return u, nil
+}
+
+// mapLen reads a map header and returns the map length
+func (r *msgpackReader) mapLen() (int, error) { return r.containerLen(0x80, 0xde) }
+
+// arrayLen reads an array header and returns the array length
+func (r *msgpackReader) arrayLen() (int, error) { return r.containerLen(0x90, 0xdc) }
+
+// containerLen reads a header of an array or a map, fix is the fixed format type byte
+// and long is the 16-bit length format type byte
+func (r *msgpackReader) containerLen(fix, long byte) (int, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return 0, err
+	}
+
+	switch t := b[0]; {
+	case t&0xf0 == fix:
+		return int(t & 0x0f), nil
+	case t == long, t == long+1:
+		n, err := r.uint(2 << (t - long))
+		return int(n), err
+	}
+	r.pos--
+	return 0, ErrorWrongPacket
+}
+
+// skip the next value without decoding it
+func (r *msgpackReader) skip() error {
+	b, err := r.next(1)
+	if err != nil {
+		return err
+	}
+
+	t := b[0]
+	switch {
+	case t <= 0x7f, t >= 0xe0:
+		return nil
+	case t&0xf0 == 0x80:
+		return r.skipValues(2 * int(t&0x0f))
+	case t&0xf0 == 0x90:
+		return r.skipValues(int(t & 0x0f))
+	case t&0xe0 == 0xa0:
+		_, err := r.next(int(t & 0x1f))
+		return err
+	}
+
+	var n uint64
+	switch t {
+	case 0xc0, 0xc2, 0xc3:
+		return nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err = r.uint(1 << (t - 0xc4))
+	case 0xd9, 0xda, 0xdb:
+		n, err = r.uint(1 << (t - 0xd9))
+	case 0xc7, 0xc8, 0xc9:
+		if n, err = r.uint(1 << (t - 0xc7)); err == nil {
+			n++
+		}
+	case 0xca:
+		n = 4
+	case 0xcb:
+		n = 8
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		n = 1 << (t - 0xcc)
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		n = 1 << (t - 0xd0)
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		n = 1<<(t-0xd4) + 1
+	case 0xdc, 0xdd:
+		if n, err = r.uint(2 << (t - 0xdc)); err != nil {
+			return err
+		}
+		return r.skipValues(int(n))
+	case 0xde, 0xdf:
+		if n, err = r.uint(2 << (t - 0xde)); err != nil {
+			return err
+		}
+		return r.skipValues(2 * int(n))
+	default:
+		return ErrorMsgpackUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return ErrorWrongPacket
+	}
+	r.pos += int(n)
+	return nil
+}
+
+// skipValues skips n following values of an array or a map
+func (r *msgpackReader) skipValues(n int) error {
+	if n < 0 || n > len(r.data)-r.pos { // each value takes at least one byte
+		return ErrorWrongPacket
+	}
+	if err := r.nest(); err != nil {
+		return err
+	}
+	defer r.leave()
+
+	for i := 0; i < n; i++ {
+		if err := r.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read the next value: integers are decoded as int64 or uint64, binary as []byte,
+// maps as map[string]interface{} and extensions as nil
+func (r *msgpackReader) read() (interface{}, error) {
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+
+	t := b[0]
+	switch {
+	case t <= 0x7f:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t&0xf0 == 0x80:
+		return r.readMap(int(t & 0x0f))
+	case t&0xf0 == 0x90:
+		return r.readArray(int(t & 0x0f))
+	case t&0xe0 == 0xa0:
+		return r.readString(int(t & 0x1f))
+	}
+
+	switch t {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, err := r.uint(1 << (t - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.next(int(n))
+		return append([]byte(nil), b...), err
+	case 0xc7, 0xc8, 0xc9:
+		n, err := r.uint(1 << (t - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		_, err = r.next(int(n) + 1)
+		return nil, err
+	case 0xca:
+		u, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(u))), err
+	case 0xcb:
+		u, err := r.uint(8)
+		return math.Float64frombits(u), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (t - 0xcc))
+	case 0xd0:
+		u, err := r.uint(1)
+		return int64(int8(u)), err
+	case 0xd1:
+		u, err := r.uint(2)
+		return int64(int16(u)), err
+	case 0xd2:
+		u, err := r.uint(4)
+		return int64(int32(u)), err
+	case 0xd3:
+		u, err := r.uint(8)
+		return int64(u), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		_, err := r.next(1<<(t-0xd4) + 1)
+		return nil, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (t - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.readString(int(n))
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (t - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.readArray(int(n))
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (t - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.readMap(int(n))
+	}
+
+	return nil, ErrorMsgpackUnsupported
+}
+
+// readString of length n
+func (r *msgpackReader) readString(n int) (string, error) {
+	b, err := r.next(n)
+	return string(b), err
+}
+
+// readArray of length n
+func (r *msgpackReader) readArray(n int) ([]interface{}, error) {
+	if n > len(r.data)-r.pos { // each element takes at least one byte
+		return nil, ErrorWrongPacket
+	}
+	if err := r.nest(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	a := make([]interface{}, n)
+	for i := range a {
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		a[i] = v
+	}
+	return a, nil
+}
+
+// readMap of length n, keys which are not strings are skipped
+func (r *msgpackReader) readMap(n int) (map[string]interface{}, error) {
+	if 2*n > len(r.data)-r.pos { // each key and value takes at least one byte
+		return nil, ErrorWrongPacket
+	}
+	if err := r.nest(); err != nil {
+		return nil, err
+	}
+	defer r.leave()
+
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		k, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		v, err := r.read()
+		if err != nil {
+			return nil, err
+		}
+		if key, ok := k.(string); ok {
+			m[key] = v
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,290 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Packets as sent by socket.io 2.x with socket.io-msgpack-parser 2.x, which encodes them with notepack.io.
+// Binary packets are prefixed with the engine.io message type byte as in websocket frames
+const (
+	// client: socket.emit("hello", "world", 1)
+	jsClientEmit = "\x04\x84\xa4type\x02\xa4data\x93\xa5hello\xa5world\x01" +
+		"\xa7options\x81\xa8compress\xc3\xa3nsp\xa1/"
+	// client: socket.emit("ask", {a: 1.5}, function () {})
+	jsClientAckRequest = "\x04\x85\xa4type\x02\xa4data\x92\xa3ask\x81\xa1a\xcb\x3f\xf8\x00\x00\x00\x00\x00\x00" +
+		"\xa7options\x81\xa8compress\xc3\xa2id\x00\xa3nsp\xa1/"
+	// client: socket.emit("file", Buffer.from([1, 2, 3]))
+	jsClientBinaryEmit = "\x04\x84\xa4type\x05\xa4data\x92\xa4file\xc4\x03\x01\x02\x03" +
+		"\xa7options\x81\xa8compress\xc3\xa3nsp\xa1/"
+	// client: socket.disconnect()
+	jsClientDisconnect = `4{"type":1,"nsp":"/"}`
+	// server: socket.emit("hello", "world", 1)
+	jsServerEmit = "\x04\x83\xa4type\x02\xa4data\x93\xa5hello\xa5world\x01\xa3nsp\xa1/"
+	// server: ack callback called with "ok"
+	jsServerAck = "\x04\x84\xa2id\x00\xa4type\x03\xa4data\x91\xa2ok\xa3nsp\xa1/"
+	// server: connect packet
+	jsServerConnect = `4{"type":0,"nsp":"/"}`
+)
+
+// mustMarshal returns msgpack encoding of values as message arguments
+func mustMarshal(t *testing.T, values ...interface{}) string {
+	t.Helper()
+	var args []byte
+	for _, v := range values {
+		b, err := MsgpackParser{}.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, b...)
+	}
+	return string(args)
+}
+
+// decodeMsgpackMap decodes the binary packet as a generic map
+func decodeMsgpackMap(t *testing.T, packet string) map[string]interface{} {
+	t.Helper()
+	r := &msgpackReader{data: []byte(packet[len(BinaryMessagePrefix):])}
+	v, err := r.read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		t.Fatalf("%q is not a map", packet)
+	}
+	return m
+}
+
+func TestMsgpackDecodeJSPackets(t *testing.T) {
+	for _, tc := range []struct {
+		packet string
+		want   Message
+	}{
+		{jsClientEmit, Message{Type: MessageTypeEmit, EventName: "hello", Args: mustMarshal(t, "world", 1)}},
+		{jsClientAckRequest, Message{Type: MessageTypeAckRequest, EventName: "ask",
+			Args: mustMarshal(t, map[string]float64{"a": 1.5})}},
+		{jsClientBinaryEmit, Message{Type: MessageTypeEmit, EventName: "file", Args: mustMarshal(t, []byte{1, 2, 3})}},
+		{jsClientDisconnect, Message{Type: MessageTypeDisconnect}},
+		{jsServerEmit, Message{Type: MessageTypeEmit, EventName: "hello", Args: mustMarshal(t, "world", 1)}},
+		{jsServerAck, Message{Type: MessageTypeAckResponse, Args: mustMarshal(t, "ok")}},
+		{jsServerConnect, Message{Type: MessageTypeEmpty}},
+	} {
+		m, err := MsgpackParser{}.Decode(tc.packet)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.packet, err)
+		}
+		tc.want.Source = tc.packet
+		if *m != tc.want {
+			t.Fatalf("%q is decoded as %+v, expected %+v", tc.packet, m, tc.want)
+		}
+	}
+}
+
+func TestMsgpackEncodeAsJS(t *testing.T) {
+	p := MsgpackParser{}
+
+	emit, err := p.Encode(&Message{Type: MessageTypeEmit, EventName: "hello", Args: mustMarshal(t, "world", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if emit != jsServerEmit {
+		t.Fatalf("emit is encoded as %q, expected %q", emit, jsServerEmit)
+	}
+
+	// key order differs from the JS packet
+	ack, err := p.Encode(&Message{Type: MessageTypeAckResponse, Args: mustMarshal(t, "ok")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decodeMsgpackMap(t, ack), decodeMsgpackMap(t, jsServerAck); !reflect.DeepEqual(got, want) {
+		t.Fatalf("ack is encoded as %v, expected %v", got, want)
+	}
+}
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	p := MsgpackParser{}
+	for _, packet := range []string{jsClientEmit, jsClientAckRequest, jsClientBinaryEmit, jsServerEmit, jsServerAck} {
+		m, err := p.Decode(packet)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoded, err := p.Encode(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := p.Decode(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded.Source = m.Source
+		if *decoded != *m {
+			t.Fatalf("%q is decoded as %+v after encoding, expected %+v", packet, decoded, m)
+		}
+	}
+}
+
+type Embedded struct {
+	ID int `json:"id"`
+}
+
+type msgpackPayload struct {
+	*Embedded
+	Name     string            `json:"name"`
+	Tags     []string          `json:"tags,omitempty"`
+	Scores   map[string]uint16 `json:"scores"`
+	Data     []byte            `json:"data"`
+	Time     time.Time         `json:"time"`
+	Skipped  string            `json:"-"`
+	Untagged bool
+	internal int
+}
+
+func TestMsgpackMarshal(t *testing.T) {
+	p := MsgpackParser{}
+	in := msgpackPayload{
+		Embedded: &Embedded{ID: -300},
+		Name:     "name",
+		Scores:   map[string]uint16{"a": 300, "b": 1},
+		Data:     []byte{0, 255},
+		Time:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Skipped:  "skipped",
+		Untagged: true,
+		internal: 1,
+	}
+
+	b, err := p.Marshal(&in)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generic := decodeMsgpackMap(t, BinaryMessagePrefix+string(b))
+	if _, ok := generic["tags"]; ok {
+		t.Fatal("empty field is not omitted")
+	}
+	if _, ok := generic["Skipped"]; ok {
+		t.Fatal("skipped field is marshaled")
+	}
+	if generic["id"] != int64(-300) || generic["time"] != "2020-01-02T03:04:05Z" || generic["Untagged"] != true {
+		t.Fatalf("fields are marshaled as %v", generic)
+	}
+
+	var out msgpackPayload
+	if err := p.Unmarshal(b, &out); err != nil {
+		t.Fatal(err)
+	}
+	in.Skipped, in.internal = "", 0
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("unmarshaled %+v, expected %+v", out, in)
+	}
+}
+
+func TestMsgpackUnmarshal(t *testing.T) {
+	p := MsgpackParser{}
+
+	// handler arguments are unmarshaled into an interface holding a pointer
+	var target interface{} = new([]int)
+	if err := p.Unmarshal([]byte(mustMarshal(t, []int{1, -2})), &target); err != nil {
+		t.Fatal(err)
+	}
+	if got := *target.(*[]int); len(got) != 2 || got[0] != 1 || got[1] != -2 {
+		t.Fatalf("unmarshaled %v", got)
+	}
+
+	var generic interface{}
+	if err := p.Unmarshal([]byte(mustMarshal(t, map[string]interface{}{"a": []interface{}{1, "x"}})), &generic); err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]interface{}{"a": []interface{}{int64(1), "x"}}; !reflect.DeepEqual(generic, want) {
+		t.Fatalf("unmarshaled %v, expected %v", generic, want)
+	}
+
+	var small int8
+	if err := p.Unmarshal([]byte(mustMarshal(t, 300)), &small); !errors.Is(err, ErrorMsgpackMismatch) {
+		t.Fatalf("overflow error is %v", err)
+	}
+
+	var s string
+	if err := p.Unmarshal([]byte(mustMarshal(t, "a", "b")), &s); err == nil {
+		t.Fatal("several values are unmarshaled into a single one")
+	}
+}
+
+func TestMsgpackMalformed(t *testing.T) {
+	p := MsgpackParser{}
+	for _, packet := range []string{
+		"40 ",
+		`42["a",1]`,
+		"\x04",
+		"\x04\x91\x01",
+		"\x04\x81\xa4type\x02",
+		"\x04\x82\xa4type\x02\xa4data\x91\x01",
+		"\x04\x82\xa4type\x03\xa4data\x90",
+		"\x04\x82\xa4type\x02\xa4data\x9f",
+		"\x04\x81\xa4type\x02\x00",
+		`4{"type":2,"data":[]}`,
+		`4{"type":0}{}`,
+	} {
+		if _, err := p.Decode(packet); err == nil {
+			t.Fatalf("malformed %q is decoded", packet)
+		}
+	}
+
+	for _, m := range []*Message{
+		{Type: MessageTypeEmpty, Args: mustMarshal(t, 1, 2)},
+		{Type: MessageTypeEmit, EventName: "a", Args: "\xdc\x00"},
+	} {
+		if _, err := p.Encode(m); !errors.Is(err, ErrorWrongPacket) {
+			t.Fatalf("%+v is encoded with error %v", m, err)
+		}
+	}
+}
+
+func TestMsgpackConnectAuth(t *testing.T) {
+	p := MsgpackParser{}
+	auth := mustMarshal(t, map[string]string{"token": "x"})
+
+	encoded, err := p.Encode(&Message{Type: MessageTypeEmpty, Args: auth})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := p.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Type != MessageTypeEmpty || !bytes.Equal([]byte(m.Args), []byte(auth)) {
+		t.Fatalf("decoded %+v", m)
+	}
+}
+
+type msgpackNode struct {
+	Name string       `json:"name"`
+	Next *msgpackNode `json:"next"`
+}
+
+func TestMsgpackMarshalCycle(t *testing.T) {
+	p := MsgpackParser{}
+
+	cyclic := &msgpackNode{Name: "a"}
+	cyclic.Next = cyclic
+	if _, err := p.Marshal(cyclic); !errors.Is(err, ErrorMsgpackDepth) {
+		t.Fatalf("cyclic value is marshaled with error %v", err)
+	}
+
+	var list []interface{}
+	list = append(list, &list)
+	if _, err := p.Marshal(list); !errors.Is(err, ErrorMsgpackDepth) {
+		t.Fatalf("cyclic slice is marshaled with error %v", err)
+	}
+
+	deep := &msgpackNode{Name: "deep"}
+	for i := 0; i < 100; i++ {
+		deep = &msgpackNode{Name: "deep", Next: deep}
+	}
+	if _, err := p.Marshal(deep); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,544 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrorMsgpackMismatch = errors.New("msgpack value doesn't match the type")
+
+// ErrorMsgpackDepth is returned by MsgpackParser.Marshal for values nested deeper than maxMsgpackDepth, like cyclic ones
+var ErrorMsgpackDepth = errors.New("msgpack value is nested too deep")
+
+var (
+	jsonMarshalerType   = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// msgpackField is a struct field marshaled as a msgpack map entry
+type msgpackField struct {
+	name      string
+	index     []int // index sequence of the field in the struct and in embedded structs
+	omitEmpty bool
+}
+
+// msgpackFieldsCache maps struct types to their fields
+var msgpackFieldsCache sync.Map
+
+// msgpackFields returns fields of the struct type t named and embedded as with encoding/json
+func msgpackFields(t reflect.Type) []msgpackField {
+	if fields, ok := msgpackFieldsCache.Load(t); ok {
+		return fields.([]msgpackField)
+	}
+
+	fields := appendMsgpackFields(nil, t, nil)
+	msgpackFieldsCache.Store(t, fields)
+	return fields
+}
+
+// appendMsgpackFields appends fields of the struct type t to fields, index is the index sequence of t
+func appendMsgpackFields(fields []msgpackField, t reflect.Type, index []int) []msgpackField {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name, options := tag, ""
+		if comma := strings.IndexByte(tag, ','); comma >= 0 {
+			name, options = tag[:comma], tag[comma:]
+		}
+		fieldIndex := append(append(make([]int, 0, len(index)+1), index...), i)
+
+		embedded := f.Type
+		if embedded.Kind() == reflect.Ptr {
+			embedded = embedded.Elem()
+		}
+		if f.Anonymous && name == "" && embedded.Kind() == reflect.Struct {
+			fields = appendMsgpackFields(fields, embedded, fieldIndex)
+			continue
+		}
+
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, msgpackField{
+			name:      name,
+			index:     fieldIndex,
+			omitEmpty: strings.Contains(options+",", ",omitempty,"),
+		})
+	}
+	return fields
+}
+
+// msgpackFieldValue returns the field of struct v by the index sequence, the second value is false
+// if the field is in a nil embedded struct pointer
+func msgpackFieldValue(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// isEmptyValue reports whether v is empty as for encoding/json omitempty option
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// writeMsgpackValue writes Go value v nested at the depth to the buffer in msgpack format.
+// Values implementing json.Marshaler are written as their JSON, encoding.TextMarshaler as strings
+func writeMsgpackValue(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	if depth > maxMsgpackDepth {
+		return ErrorMsgpackDepth
+	}
+
+	if !v.IsValid() || (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		buf.WriteByte(msgpackNil)
+		return nil
+	}
+
+	if v.Kind() != reflect.Interface {
+		if v.Kind() != reflect.Ptr && v.CanAddr() && reflect.PtrTo(v.Type()).Implements(jsonMarshalerType) {
+			v = v.Addr()
+		}
+		if m, ok := v.Interface().(json.Marshaler); ok {
+			return writeMsgpackJSON(buf, m)
+		}
+		if m, ok := v.Interface().(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			if err != nil {
+				return err
+			}
+			return writeMsgpack(buf, string(text))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return writeMsgpackValue(buf, v.Elem(), depth+1)
+	case reflect.Bool:
+		writeMsgpackBool(buf, v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeMsgpackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeMsgpackUint(buf, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		writeMsgpackFloat(buf, v.Float())
+	case reflect.String:
+		writeMsgpack(buf, v.String())
+	case reflect.Slice:
+		if v.IsNil() {
+			buf.WriteByte(msgpackNil)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			writeMsgpackBin(buf, v.Bytes())
+			return nil
+		}
+		return writeMsgpackArray(buf, v, depth)
+	case reflect.Array:
+		return writeMsgpackArray(buf, v, depth)
+	case reflect.Map:
+		return writeMsgpackMap(buf, v, depth)
+	case reflect.Struct:
+		return writeMsgpackStruct(buf, v, depth)
+	default:
+		return ErrorMsgpackUnsupported
+	}
+	return nil
+}
+
+// writeMsgpackJSON writes the JSON encoding of m to the buffer in msgpack format
+func writeMsgpackJSON(buf *bytes.Buffer, m json.Marshaler) error {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+
+	var v interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	return writeMsgpack(buf, v)
+}
+
+// writeMsgpackArray writes slice or array v to the buffer
+func writeMsgpackArray(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	writeMsgpackLen(buf, v.Len(), 0x90, 0xdc)
+	for i := 0; i < v.Len(); i++ {
+		if err := writeMsgpackValue(buf, v.Index(i), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeMsgpackMap writes map v to the buffer with keys sorted, keys are written as strings
+func writeMsgpackMap(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	if v.IsNil() {
+		buf.WriteByte(msgpackNil)
+		return nil
+	}
+
+	type entry struct {
+		key   string
+		value reflect.Value
+	}
+	entries := make([]entry, 0, v.Len())
+	for it := v.MapRange(); it.Next(); {
+		key, err := msgpackMapKey(it.Key())
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{key, it.Value()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+
+	writeMsgpackLen(buf, len(entries), 0x80, 0xde)
+	for _, e := range entries {
+		writeMsgpack(buf, e.key)
+		if err := writeMsgpackValue(buf, e.value, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackMapKey returns map key k as a string like encoding/json does
+func msgpackMapKey(k reflect.Value) (string, error) {
+	if k.Kind() == reflect.String {
+		return k.String(), nil
+	}
+	if m, ok := k.Interface().(encoding.TextMarshaler); ok {
+		text, err := m.MarshalText()
+		return string(text), err
+	}
+
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(k.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(k.Uint(), 10), nil
+	}
+	return "", ErrorMsgpackUnsupported
+}
+
+// writeMsgpackStruct writes struct v to the buffer as a map of it's fields
+func writeMsgpackStruct(buf *bytes.Buffer, v reflect.Value, depth int) error {
+	fields := msgpackFields(v.Type())
+	values := make([]reflect.Value, len(fields))
+	n := 0
+	for i, f := range fields {
+		fv, ok := msgpackFieldValue(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		values[i] = fv
+		n++
+	}
+
+	writeMsgpackLen(buf, n, 0x80, 0xde)
+	for i, f := range fields {
+		if !values[i].IsValid() {
+			continue
+		}
+		writeMsgpack(buf, f.name)
+		if err := writeMsgpackValue(buf, values[i], depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mismatchError returns an error for the decoded msgpack value src which doesn't fit the type of dst
+func mismatchError(src interface{}, dst reflect.Value) error {
+	return fmt.Errorf("%w: %T into %s", ErrorMsgpackMismatch, src, dst.Type())
+}
+
+// setMsgpackValue sets value src decoded by msgpackReader to dst like encoding/json does with JSON values.
+// Types implementing json.Unmarshaler get src as JSON, encoding.TextUnmarshaler get src strings
+func setMsgpackValue(dst reflect.Value, src interface{}) error {
+	if dst.Kind() != reflect.Ptr && dst.CanAddr() {
+		ptr := dst.Addr()
+		if ptr.Type().Implements(jsonUnmarshalerType) {
+			b, err := json.Marshal(src)
+			if err != nil {
+				return err
+			}
+			return ptr.Interface().(json.Unmarshaler).UnmarshalJSON(b)
+		}
+		if s, ok := src.(string); ok && ptr.Type().Implements(textUnmarshalerType) {
+			return ptr.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		}
+	}
+
+	if src == nil {
+		switch dst.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			dst.Set(reflect.Zero(dst.Type()))
+		}
+		return nil
+	}
+
+	switch dst.Kind() {
+	case reflect.Ptr:
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return setMsgpackValue(dst.Elem(), src)
+
+	case reflect.Interface:
+		// decode into the value pointed by the interface as encoding/json does
+		if elem := dst.Elem(); elem.Kind() == reflect.Ptr && !elem.IsNil() {
+			return setMsgpackValue(elem, src)
+		}
+		if dst.NumMethod() != 0 {
+			return mismatchError(src, dst)
+		}
+		dst.Set(reflect.ValueOf(src))
+
+	case reflect.Bool:
+		b, ok := src.(bool)
+		if !ok {
+			return mismatchError(src, dst)
+		}
+		dst.SetBool(b)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := msgpackInt(src)
+		if !ok || dst.OverflowInt(i) {
+			return mismatchError(src, dst)
+		}
+		dst.SetInt(i)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch v := src.(type) {
+		case int64:
+			if v < 0 {
+				return mismatchError(src, dst)
+			}
+			u = uint64(v)
+		case uint64:
+			u = v
+		default:
+			return mismatchError(src, dst)
+		}
+		if dst.OverflowUint(u) {
+			return mismatchError(src, dst)
+		}
+		dst.SetUint(u)
+
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch v := src.(type) {
+		case float64:
+			f = v
+		case int64:
+			f = float64(v)
+		case uint64:
+			f = float64(v)
+		default:
+			return mismatchError(src, dst)
+		}
+		dst.SetFloat(f)
+
+	case reflect.String:
+		switch v := src.(type) {
+		case string:
+			dst.SetString(v)
+		case []byte:
+			dst.SetString(string(v))
+		default:
+			return mismatchError(src, dst)
+		}
+
+	case reflect.Slice:
+		if dst.Type().Elem().Kind() == reflect.Uint8 {
+			switch v := src.(type) {
+			case []byte:
+				dst.SetBytes(v)
+				return nil
+			case string:
+				dst.SetBytes([]byte(v))
+				return nil
+			}
+		}
+		items, ok := src.([]interface{})
+		if !ok {
+			return mismatchError(src, dst)
+		}
+		slice := reflect.MakeSlice(dst.Type(), len(items), len(items))
+		for i, item := range items {
+			if err := setMsgpackValue(slice.Index(i), item); err != nil {
+				return err
+			}
+		}
+		dst.Set(slice)
+
+	case reflect.Array:
+		items, ok := src.([]interface{})
+		if !ok {
+			return mismatchError(src, dst)
+		}
+		for i := 0; i < dst.Len(); i++ {
+			if i >= len(items) {
+				dst.Index(i).Set(reflect.Zero(dst.Type().Elem()))
+				continue
+			}
+			if err := setMsgpackValue(dst.Index(i), items[i]); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		return setMsgpackMap(dst, src)
+
+	case reflect.Struct:
+		return setMsgpackStruct(dst, src)
+
+	default:
+		return mismatchError(src, dst)
+	}
+	return nil
+}
+
+// setMsgpackMap sets decoded msgpack map src to map dst, keys are converted as encoding/json does
+func setMsgpackMap(dst reflect.Value, src interface{}) error {
+	entries, ok := src.(map[string]interface{})
+	if !ok {
+		return mismatchError(src, dst)
+	}
+
+	t := dst.Type()
+	if dst.IsNil() {
+		dst.Set(reflect.MakeMapWithSize(t, len(entries)))
+	}
+
+	for key, value := range entries {
+		k := reflect.New(t.Key()).Elem()
+		switch {
+		case t.Key().Kind() == reflect.String:
+			k.SetString(key)
+		case reflect.PtrTo(t.Key()).Implements(textUnmarshalerType):
+			if err := k.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(key)); err != nil {
+				return err
+			}
+		default:
+			if err := setMsgpackMapKey(k, key); err != nil {
+				return err
+			}
+		}
+
+		v := reflect.New(t.Elem()).Elem()
+		if err := setMsgpackValue(v, value); err != nil {
+			return err
+		}
+		dst.SetMapIndex(k, v)
+	}
+	return nil
+}
+
+// setMsgpackMapKey sets integer map key k from it's string representation
+func setMsgpackMapKey(k reflect.Value, key string) error {
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(key, 10, 64)
+		if err != nil || k.OverflowInt(i) {
+			return mismatchError(key, k)
+		}
+		k.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(key, 10, 64)
+		if err != nil || k.OverflowUint(u) {
+			return mismatchError(key, k)
+		}
+		k.SetUint(u)
+	default:
+		return mismatchError(key, k)
+	}
+	return nil
+}
+
+// setMsgpackStruct sets fields of struct dst from decoded msgpack map src, keys are matched
+// to field names as encoding/json does, preferring an exact match
+func setMsgpackStruct(dst reflect.Value, src interface{}) error {
+	entries, ok := src.(map[string]interface{})
+	if !ok {
+		return mismatchError(src, dst)
+	}
+
+	fields := msgpackFields(dst.Type())
+	for key, value := range entries {
+		var field *msgpackField
+		for i := range fields {
+			if fields[i].name == key {
+				field = &fields[i]
+				break
+			}
+			if field == nil && strings.EqualFold(fields[i].name, key) {
+				field = &fields[i]
+			}
+		}
+		if field == nil {
+			continue
+		}
+
+		fv := dst
+		for i, x := range field.index {
+			if i > 0 && fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					if !fv.CanSet() { // unexported embedded struct pointer
+						fv = reflect.Value{}
+						break
+					}
+					fv.Set(reflect.New(fv.Type().Elem()))
+				}
+				fv = fv.Elem()
+			}
+			fv = fv.Field(x)
+		}
+		if !fv.IsValid() {
+			continue
+		}
+		if err := setMsgpackValue(fv, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// Parser encodes and decodes socket.io packets, and marshals and unmarshals event payloads.
+// Message.Args holds arguments encoded by the parser's Marshal: a comma separated list of JSON values
+// for text parsers, or consecutive msgpack values for MsgpackParser. Raw payloads, like json.RawMessage
+// handler arguments, EmitRaw payloads and ack responses, are encoded the same way
+type Parser interface {
+	Encode(m *Message) (string, error)
+	Decode(data string) (*Message, error)
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// DefaultParser is a parser used if no other is set
+var DefaultParser Parser = JSONParser{}
+
+// JSONParser is the default socket.io text packets parser using encoding/json for payloads.
+// Embed it to a custom parser overriding Marshal and Unmarshal to use other JSON implementation
+type JSONParser struct{}
+
+// Encode a socket.io message m to the text packet
+func (JSONParser) Encode(m *Message) (string, error) { return Encode(m) }
+
+// Decode the given text packet into a Message
+func (JSONParser) Decode(data string) (*Message, error) { return Decode(data) }
+
+// Marshal returns JSON encoding of v
+func (JSONParser) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+// Unmarshal JSON data into v
+func (JSONParser) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
@@ -9,20 +9,20 @@ import (
 )
 
 const (
-	MessageOpen        = "0"
-	MessageClose       = "1"
-	MessagePing        = "2"
-	MessagePingProbe   = "2probe"
-	MessagePongProbe   = "3probe"
-	MessagePong        = "3"
-	messageMSG         = "4"
-	MessageEmpty       = "40"
-	messageCloseClient = "41"
-	messageCommon      = "42"
-	messageACK         = "43"
-	MessageUpgrade     = "5"
-	MessageBlank       = "6"
-	MessageStub        = "stub"
+	MessageOpen       = "0"
+	MessageClose      = "1"
+	MessagePing       = "2"
+	MessagePingProbe  = "2probe"
+	MessagePongProbe  = "3probe"
+	MessagePong       = "3"
+	messageMSG        = "4"
+	MessageEmpty      = "40"
+	MessageDisconnect = "41"
+	messageCommon     = "42"
+	messageACK        = "43"
+	MessageUpgrade    = "5"
+	MessageBlank      = "6"
+	MessageStub       = "stub"
 )
 
 var (
@@ -30,6 +30,34 @@ var (
 	ErrorWrongPacket      = errors.New("wrong packet")
 )
 
+// maxPacketErrorSource is the length the packet is truncated to in PacketError description
+const maxPacketErrorSource = 64
+
+// PacketError describes malformed packet, it wraps ErrorWrongMessageType or ErrorWrongPacket
+type PacketError struct {
+	Packet string // the malformed packet
+	Offset int    // the offset in the packet the error was found at
+	Reason string
+	Err    error
+}
+
+// newPacketError returns *PacketError for the packet data
+func newPacketError(data string, offset int, err error, reason string) *PacketError {
+	return &PacketError{Packet: data, Offset: offset, Reason: reason, Err: err}
+}
+
+// Error implements error interface
+func (e *PacketError) Error() string {
+	packet := e.Packet
+	if len(packet) > maxPacketErrorSource {
+		packet = packet[:maxPacketErrorSource] + "..."
+	}
+	return fmt.Sprintf("%v: %s at offset %d of %q", e.Err, e.Reason, e.Offset, packet)
+}
+
+// Unwrap returns the wrapped error
+func (e *PacketError) Unwrap() error { return e.Err }
+
 func typeToText(mType int) (string, error) {
 	codesToNames := map[int]string{
 		MessageTypeOpen:        MessageOpen,
@@ -40,6 +68,7 @@ func typeToText(mType int) (string, error) {
 		MessageTypeEmit:        messageCommon,
 		MessageTypeAckRequest:  messageCommon,
 		MessageTypeAckResponse: messageACK,
+		MessageTypeDisconnect:  MessageDisconnect,
 	}
 	mName, exists := codesToNames[mType]
 	if !exists {
@@ -56,8 +85,10 @@ func Encode(m *Message) (string, error) {
 	}
 
 	switch m.Type {
-	case MessageTypeEmpty, MessageTypePing, MessageTypePong:
+	case MessageTypePing, MessageTypePong, MessageTypeDisconnect:
 		return result, nil
+	case MessageTypeEmpty:
+		return result + m.Args, nil
 	case MessageTypeAckRequest:
 		result += strconv.Itoa(m.AckID)
 	case MessageTypeAckResponse:
@@ -72,6 +103,9 @@ func Encode(m *Message) (string, error) {
 		return "", err
 	}
 
+	if m.Args == "" {
+		return fmt.Sprintf(`%s[%s]`, result, string(jsonMethod)), nil
+	}
 	return fmt.Sprintf(`%s[%s,%s]`, result, string(jsonMethod), m.Args), nil
 }
 
@@ -84,130 +118,156 @@ func MustEncode(m *Message) string {
 	return result
 }
 
-func getMessageType(data string) (int, error) {
+// getMessageType returns a type of the packet in data and the offset of the rest of the packet
+func getMessageType(data string) (int, int, error) {
 	if len(data) == 0 {
-		return 0, ErrorWrongMessageType
+		return 0, 0, newPacketError(data, 0, ErrorWrongMessageType, "empty packet")
 	}
+
 	switch data[0:1] {
 	case MessageOpen:
-		return MessageTypeOpen, nil
+		return MessageTypeOpen, 1, nil
 	case MessageClose:
-		return MessageTypeClose, nil
+		return MessageTypeClose, 1, nil
 	case MessagePing:
-		return MessageTypePing, nil
+		return MessageTypePing, 1, nil
 	case MessagePong:
-		return MessageTypePong, nil
+		return MessageTypePong, 1, nil
 	case MessageUpgrade:
-		return MessageTypeUpgrade, nil
+		return MessageTypeUpgrade, 1, nil
 	case MessageBlank:
-		return MessageTypeBlank, nil
+		return MessageTypeBlank, 1, nil
 	case messageMSG:
 		if len(data) == 1 {
-			return 0, ErrorWrongMessageType
+			return 0, 1, newPacketError(data, 1, ErrorWrongMessageType, "missing socket.io packet type")
 		}
 		switch data[0:2] {
 		case MessageEmpty:
-			return MessageTypeEmpty, nil
-		case messageCloseClient:
-			return MessageTypeClose, nil
+			return MessageTypeEmpty, 2, nil
+		case MessageDisconnect:
+			return MessageTypeDisconnect, 2, nil
 		case messageCommon:
-			return MessageTypeAckRequest, nil
+			return MessageTypeEmit, 2, nil
 		case messageACK:
-			return MessageTypeAckResponse, nil
+			return MessageTypeAckResponse, 2, nil
 		}
+		return 0, 1, newPacketError(data, 1, ErrorWrongMessageType, "unsupported socket.io packet type")
 	}
-	return 0, ErrorWrongMessageType
+
+	return 0, 0, newPacketError(data, 0, ErrorWrongMessageType, "unsupported engine.io packet type")
 }
 
-// getAck extracts an id of the current packet if present
-func getAck(text string) (ackId int, restText string, err error) {
-	if len(text) < 4 {
-		return 0, "", ErrorWrongPacket
+// skipNamespace returns the offset following the namespace of the socket.io packet if present
+func skipNamespace(data string, pos int) int {
+	if pos >= len(data) || data[pos] != '/' {
+		return pos
 	}
-	text = text[2:]
 
-	pos := strings.IndexByte(text, '[')
-	if pos == -1 {
-		return 0, "", ErrorWrongPacket
+	end := strings.IndexByte(data[pos:], ',')
+	if end < 0 {
+		return len(data)
 	}
+	return pos + end + 1
+}
 
-	ack, err := strconv.Atoi(text[0:pos])
-	if err != nil {
-		return 0, "", err
+// getAck extracts an id of the socket.io packet if present, and returns the offset following it
+func getAck(data string, pos int) (ackID int, hasAck bool, next int, err error) {
+	next = pos
+	for next < len(data) && data[next] >= '0' && data[next] <= '9' {
+		next++
+	}
+	if next == pos {
+		return 0, false, pos, nil
 	}
 
-	return ack, text[pos:], nil
+	ackID, err = strconv.Atoi(data[pos:next])
+	if err != nil {
+		return 0, false, pos, newPacketError(data, pos, ErrorWrongPacket, "invalid ack id")
+	}
+	return ackID, true, next, nil
 }
 
-// getMethod extracts a message event name of the current packet if present
-func getMethod(text string) (event, restText string, err error) {
-	var start, end, rest, countQuote int
-
-	for i, c := range text {
-		if c == '"' {
-			switch countQuote {
-			case 0:
-				start = i + 1
-			case 1:
-				end, rest = i, i+1
-			default:
-				return "", "", ErrorWrongPacket
-			}
-			countQuote++
-		}
-		if c == ',' {
-			if countQuote < 2 {
-				continue
-			}
-			rest = i + 1
-			break
-		}
+// getArgs decodes a JSON array of the socket.io packet at pos, and returns it's elements
+func getArgs(data string, pos int) ([]json.RawMessage, error) {
+	if pos >= len(data) || data[pos] != '[' {
+		return nil, newPacketError(data, pos, ErrorWrongPacket, "expected JSON array")
 	}
 
-	if (end < start) || (rest >= len(text)) {
-		return "", "", ErrorWrongPacket
+	var args []json.RawMessage
+	if err := json.Unmarshal([]byte(data[pos:]), &args); err != nil {
+		return nil, newPacketError(data, pos, ErrorWrongPacket, "malformed JSON array: "+err.Error())
 	}
+	return args, nil
+}
 
-	return text[start:end], text[rest : len(text)-1], nil
+// joinArgs returns arguments as a comma separated JSON text
+func joinArgs(args []json.RawMessage) string {
+	encoded := make([]string, len(args))
+	for i, arg := range args {
+		encoded[i] = string(arg)
+	}
+	return strings.Join(encoded, ",")
 }
 
-// Decode the given data string into a Message
+// Decode the given data string into a Message. Malformed data results in *PacketError
 func Decode(data string) (*Message, error) {
 	var err error
 	m := &Message{Source: data}
 
-	m.Type, err = getMessageType(data)
+	var pos int
+	m.Type, pos, err = getMessageType(data)
 	if err != nil {
 		return nil, err
 	}
 
 	switch m.Type {
-	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeEmpty, MessageTypeBlank:
+	case MessageTypeUpgrade, MessageTypeClose, MessageTypePing, MessageTypePong, MessageTypeBlank:
 		return m, nil
 	case MessageTypeOpen:
-		m.Args = data[1:]
+		m.Args = data[pos:]
 		return m, nil
 	}
 
-	ack, rest, err := getAck(data)
-	m.AckID = ack
-	if m.Type == MessageTypeAckResponse {
-		if err != nil {
-			return nil, err
-		}
-		m.Args = rest[1 : len(rest)-1]
+	pos = skipNamespace(data, pos)
+
+	switch m.Type {
+	case MessageTypeEmpty:
+		m.Args = data[pos:]
+		return m, nil
+	case MessageTypeDisconnect:
 		return m, nil
 	}
 
+	var hasAck bool
+	m.AckID, hasAck, pos, err = getAck(data, pos)
 	if err != nil {
-		m.Type = MessageTypeEmit
-		rest = data[2:]
+		return nil, err
 	}
 
-	m.EventName, m.Args, err = getMethod(rest)
+	args, err := getArgs(data, pos)
 	if err != nil {
 		return nil, err
 	}
 
+	if m.Type == MessageTypeAckResponse {
+		if !hasAck {
+			return nil, newPacketError(data, pos, ErrorWrongPacket, "missing ack id")
+		}
+		m.Args = joinArgs(args)
+		return m, nil
+	}
+
+	if hasAck {
+		m.Type = MessageTypeAckRequest
+	}
+
+	if len(args) == 0 {
+		return nil, newPacketError(data, pos, ErrorWrongPacket, "missing event name")
+	}
+	if args[0][0] != '"' || json.Unmarshal(args[0], &m.EventName) != nil {
+		return nil, newPacketError(data, pos, ErrorWrongPacket, "event name is not a string")
+	}
+	m.Args = joinArgs(args[1:])
+
 	return m, nil
 }
@@ -0,0 +1,134 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	for _, tc := range []struct {
+		packet string
+		want   Message
+	}{
+		{`42["a\"b",1,"x"]`, Message{Type: MessageTypeEmit, EventName: `a"b`, Args: `1,"x"`}},
+		{`42["ev","s,t",{"a":"]"}]`, Message{Type: MessageTypeEmit, EventName: "ev", Args: `"s,t",{"a":"]"}`}},
+		{`4212["ev","s"]`, Message{Type: MessageTypeAckRequest, AckID: 12, EventName: "ev", Args: `"s"`}},
+		{`42/chat,["ev"]`, Message{Type: MessageTypeEmit, EventName: "ev"}},
+		{`42/chat,7["ev"]`, Message{Type: MessageTypeAckRequest, AckID: 7, EventName: "ev"}},
+		{`43/,5[]`, Message{Type: MessageTypeAckResponse, AckID: 5}},
+		{`435[{"a":[1]},2]`, Message{Type: MessageTypeAckResponse, AckID: 5, Args: `{"a":[1]},2`}},
+		{`40`, Message{Type: MessageTypeEmpty}},
+		{`40{"token":"x"}`, Message{Type: MessageTypeEmpty, Args: `{"token":"x"}`}},
+		{`41`, Message{Type: MessageTypeDisconnect}},
+		{`2probe`, Message{Type: MessageTypePing}},
+		{`3`, Message{Type: MessageTypePong}},
+	} {
+		m, err := Decode(tc.packet)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.packet, err)
+		}
+		tc.want.Source = tc.packet
+		if *m != tc.want {
+			t.Fatalf("%q is decoded as %+v, expected %+v", tc.packet, m, tc.want)
+		}
+	}
+}
+
+func TestDecodeMalformed(t *testing.T) {
+	for _, tc := range []struct {
+		packet string
+		err    error
+		offset int
+	}{
+		{"", ErrorWrongMessageType, 0},
+		{"x", ErrorWrongMessageType, 0},
+		{"4", ErrorWrongMessageType, 1},
+		{"47", ErrorWrongMessageType, 1},
+		{"42", ErrorWrongPacket, 2},
+		{"43", ErrorWrongPacket, 2},
+		{"42[", ErrorWrongPacket, 2},
+		{"42[]", ErrorWrongPacket, 2},
+		{"42[1]", ErrorWrongPacket, 2},
+		{"42[null]", ErrorWrongPacket, 2},
+		{`42["a"]x`, ErrorWrongPacket, 2},
+		{"43[1]", ErrorWrongPacket, 2},
+		{`42/chat,x["a"]`, ErrorWrongPacket, 8},
+		{`4299999999999999999999["a"]`, ErrorWrongPacket, 2},
+	} {
+		_, err := Decode(tc.packet)
+		var packetErr *PacketError
+		if !errors.As(err, &packetErr) {
+			t.Fatalf("%q: error %v is not *PacketError", tc.packet, err)
+		}
+		if !errors.Is(err, tc.err) || packetErr.Offset != tc.offset || packetErr.Packet != tc.packet {
+			t.Fatalf("%q: error is %#v, expected %v at offset %d", tc.packet, packetErr, tc.err, tc.offset)
+		}
+	}
+}
+
+func TestMsgpackDecodeMalformed(t *testing.T) {
+	for _, packet := range []string{"\x04", "\x04\x91", "\x04\xdd\xff\xff\xff\xff", "\x04\x81\xa4type\xc1", "40 "} {
+		_, err := MsgpackParser{}.Decode(packet)
+		var packetErr *PacketError
+		if !errors.As(err, &packetErr) {
+			t.Fatalf("%q: error %v is not *PacketError", packet, err)
+		}
+	}
+}
+
+// checkRoundTrip checks that the message decoded from data by p is decoded the same after encoding
+func checkRoundTrip(t *testing.T, p Parser, data string) {
+	m, err := p.Decode(data)
+	if err != nil {
+		var packetErr *PacketError
+		if !errors.As(err, &packetErr) {
+			t.Fatalf("%q: error %v is not *PacketError", data, err)
+		}
+		return
+	}
+
+	encoded, err := p.Encode(m)
+	if err != nil {
+		return // engine.io packets like upgrade are not encoded
+	}
+	decoded, err := p.Decode(encoded)
+	if err != nil {
+		t.Fatalf("%q is encoded as %q which is not decoded: %v", data, encoded, err)
+	}
+	if decoded.Type != m.Type || decoded.AckID != m.AckID || decoded.EventName != m.EventName || decoded.Args != m.Args {
+		t.Fatalf("%q is decoded as %+v, and as %+v after encoding", data, m, decoded)
+	}
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"43",
+		`42["a\"b",1]`,
+		`42["a","b,c"]`,
+		`42[" ",{"x":"]"}]`,
+		`42/chat,["a"]`,
+		`42/chat,5["a"]`,
+		`43/chat,5["ok"]`,
+		`42x["a"]`,
+		`4212x["a"]`,
+		`4299999999999999999999["a"]`,
+		`43["a"]`,
+		"40",
+		"40 ",
+		`40{"token":"x"}`,
+		"41",
+		"2probe",
+		`0{"sid":"x"}`,
+		"\x04\x83\xa4type\x02\xa4data\x93\xa5hello\xa5world\x01\xa3nsp\xa1/",
+		"\x04\x84\xa2id\x00\xa4type\x03\xa4data\x91\xa2ok\xa3nsp\xa1/",
+		"\x04\x83\xa4type\x00\xa4data\x81\xa1a\x01\xa3nsp\xa1/",
+		`4{"type":0,"nsp":"/"}`,
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data string) {
+		checkRoundTrip(t, JSONParser{}, data)
+		checkRoundTrip(t, MsgpackParser{}, data)
+	})
+}
@@ -0,0 +1,182 @@
+package gosocketio
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mtfelian/golang-socketio/protocol"
+)
+
+// tokenBucket allows events at the given rate with bursts, it's not safe for concurrent use
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token at the time now, returns false if there are no tokens left
+func (b *tokenBucket) allow(now time.Time, rate float64, burst int) bool {
+	b.refill(now, rate, burst)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// refill the bucket with tokens accumulated since the last refill
+func (b *tokenBucket) refill(now time.Time, rate float64, burst int) {
+	if b.last.IsZero() {
+		b.tokens = float64(burst)
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+	}
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+}
+
+// rateLimiter holds token buckets per key
+type rateLimiter struct {
+	buckets   map[string]*tokenBucket
+	cleanedAt time.Time
+	mu        sync.Mutex
+}
+
+// newRateLimiter returns an initialized rate limiter
+func newRateLimiter() *rateLimiter { return &rateLimiter{buckets: make(map[string]*tokenBucket)} }
+
+// allow takes a token from the key's bucket, returns false if there are no tokens left
+func (l *rateLimiter) allow(key string, rate float64, burst int) bool {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.cleanup(now, rate, burst)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		l.buckets[key] = b
+	}
+	return b.allow(now, rate, burst)
+}
+
+// cleanup removes full buckets, which are the same as absent ones, once per full refill time
+func (l *rateLimiter) cleanup(now time.Time, rate float64, burst int) {
+	if now.Sub(l.cleanedAt).Seconds()*rate < float64(burst) {
+		return
+	}
+	l.cleanedAt = now
+
+	for key, b := range l.buckets {
+		b.refill(now, rate, burst)
+		if b.tokens >= float64(burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// EventRateLimited is emitted to the remote side with the event name when RatePolicyWarn drops an event
+const EventRateLimited = "rate:limited"
+
+// channelRateKey is a key of the channel-wide bucket among the channel's event buckets
+const channelRateKey = "\x00"
+
+// RatePolicy defines what happens to an incoming event exceeding the rate limit
+type RatePolicy int
+
+const (
+	RatePolicyDrop       RatePolicy = iota // the event is dropped
+	RatePolicyErrorAck                     // the event is dropped, ack request is answered with an error
+	RatePolicyWarn                         // the event is dropped, and EventRateLimited is emitted
+	RatePolicyDisconnect                   // the channel is disconnected with ReasonRateLimited
+)
+
+// RateLimit limits incoming events with a token bucket, zero Rate means no limit
+type RateLimit struct {
+	Rate   float64 // events per second
+	Burst  int     // events allowed at once, 1 is used if it's zero
+	Policy RatePolicy
+}
+
+// rateLimitError is an ack response to the event dropped with RatePolicyErrorAck
+type rateLimitError struct {
+	Error string `json:"error"`
+}
+
+// SetRateLimit limits all incoming events of each channel, it should be set before connecting
+func (e *event) SetRateLimit(limit RateLimit) {
+	e.handlersMu.Lock()
+	e.rateLimit = limit
+	e.handlersMu.Unlock()
+}
+
+// SetEventRateLimit limits incoming events with the given name of each channel,
+// it applies after the channel-wide limit. It should be set before connecting
+func (e *event) SetEventRateLimit(name string, limit RateLimit) {
+	e.handlersMu.Lock()
+	if e.eventRateLimits == nil {
+		e.eventRateLimits = make(map[string]RateLimit)
+	}
+	e.eventRateLimits[name] = limit
+	e.handlersMu.Unlock()
+}
+
+// allowIncoming checks the incoming event m against rate limits and applies the policy of the exceeded one,
+// returns false if the event should not be processed. It's called by the channel's inLoop only
+func (e *event) allowIncoming(c *Channel, m *protocol.Message) bool {
+	if m.Type != protocol.MessageTypeEmit && m.Type != protocol.MessageTypeAckRequest {
+		return true
+	}
+
+	e.handlersMu.RLock()
+	channelLimit := e.rateLimit
+	eventLimit, hasEventLimit := e.eventRateLimits[m.EventName]
+	e.handlersMu.RUnlock()
+
+	now := time.Now()
+	limit, ok := channelLimit, c.allowRate(channelRateKey, channelLimit, now)
+	if ok && hasEventLimit {
+		limit, ok = eventLimit, c.allowRate(m.EventName, eventLimit, now)
+	}
+	if ok {
+		return true
+	}
+
+	e.callError(c, m.EventName, ErrorRateLimited, nil)
+
+	switch limit.Policy {
+	case RatePolicyErrorAck:
+		if m.Type == protocol.MessageTypeAckRequest {
+			ackResponse := &protocol.Message{Type: protocol.MessageTypeAckResponse, AckID: m.AckID}
+			c.send(ackResponse, rateLimitError{Error: ErrorRateLimited.Error()})
+		}
+	case RatePolicyWarn:
+		c.Emit(EventRateLimited, m.EventName)
+	case RatePolicyDisconnect:
+		// inLoop doesn't read further messages while disconnecting and stops after it
+		c.Disconnect(ReasonRateLimited)
+	}
+	return false
+}
+
+// allowRate takes a token from the channel's bucket of the key within the limit
+func (c *Channel) allowRate(key string, limit RateLimit, now time.Time) bool {
+	if limit.Rate <= 0 {
+		return true
+	}
+
+	burst := limit.Burst
+	if burst < 1 {
+		burst = 1
+	}
+
+	b, ok := c.rateBuckets[key]
+	if !ok {
+		b = &tokenBucket{}
+		c.rateBuckets[key] = b
+	}
+	return b.allow(now, limit.Rate, burst)
+}
@@ -0,0 +1,47 @@
+package gosocketio
+
+import (
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRateLimitDisconnectSpam(t *testing.T) {
+	s := NewServer()
+	s.SetEventRateLimit("spam", RateLimit{Rate: 0.001, Burst: 1, Policy: RatePolicyDisconnect})
+	reasonC := make(chan DisconnectReason, 1)
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { reasonC <- reason })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+
+		"/socket.io/?EIO=3&transport=websocket", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	baseline := runtime.NumGoroutine()
+	for i := 0; i < 300; i++ {
+		if err := socket.WriteMessage(websocket.TextMessage, []byte(`42["spam"]`)); err != nil {
+			break // closed by the server
+		}
+	}
+
+	select {
+	case reason := <-reasonC:
+		if reason != ReasonRateLimited {
+			t.Fatalf("disconnect reason is %v, expected %v", reason, ReasonRateLimited)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("spamming channel is not disconnected")
+	}
+	// disconnecting goroutines would wait for the flush until the ping timeout
+	if n := runtime.NumGoroutine(); n > baseline {
+		t.Fatalf("%d goroutines after disconnection, %d before", n, baseline)
+	}
+}
@@ -0,0 +1,221 @@
+package gosocketio
+
+import (
+	"hash/fnv"
+	"sync"
+)
+
+// registryShardsCount is an amount of shards in rooms and sids registries, should be a power of two
+const registryShardsCount = 64
+
+// shardIndex returns a shard index for the given key
+func shardIndex(key string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return h.Sum32() & (registryShardsCount - 1)
+}
+
+// roomShard is a part of rooms registry guarded by it's own lock
+type roomShard struct {
+	sync.RWMutex
+	channels map[string]map[*Channel]struct{} // maps room name to map of channels to an empty struct
+}
+
+// roomRegistry maps room names to joined channels, rooms are spread over the shards by name
+// so operations on different rooms don't contend on a single lock
+type roomRegistry struct {
+	shards [registryShardsCount]roomShard
+}
+
+// newRoomRegistry returns an initialized rooms registry
+func newRoomRegistry() *roomRegistry {
+	r := &roomRegistry{}
+	for i := range r.shards {
+		r.shards[i].channels = make(map[string]map[*Channel]struct{})
+	}
+	return r
+}
+
+// shard returns a shard holding the given room
+func (r *roomRegistry) shard(room string) *roomShard { return &r.shards[shardIndex(room)] }
+
+// join adds channel c to the room. It returns joined as false if c was already in the room,
+// and created as true if c is the first channel in the room
+func (r *roomRegistry) join(room string, c *Channel) (joined, created bool) {
+	shard := r.shard(room)
+	shard.Lock()
+	defer shard.Unlock()
+
+	roomChannels, exists := shard.channels[room]
+	if !exists {
+		roomChannels = make(map[*Channel]struct{})
+		shard.channels[room] = roomChannels
+	}
+
+	if _, ok := roomChannels[c]; ok {
+		return false, false
+	}
+
+	roomChannels[c] = struct{}{}
+	return true, !exists
+}
+
+// leave removes channel c from the room, the room is deleted after the last channel leaves it.
+// It returns left as false if c was not in the room, and deleted as true if the room was deleted
+func (r *roomRegistry) leave(room string, c *Channel) (left, deleted bool) {
+	shard := r.shard(room)
+	shard.Lock()
+	defer shard.Unlock()
+
+	roomChannels, ok := shard.channels[room]
+	if !ok {
+		return false, false
+	}
+
+	if _, ok := roomChannels[c]; !ok {
+		return false, false
+	}
+
+	delete(roomChannels, c)
+	if len(roomChannels) == 0 {
+		delete(shard.channels, room)
+		return true, true
+	}
+	return true, false
+}
+
+// replace channel old with channel c in the room, if old is joined to it
+func (r *roomRegistry) replace(room string, old, c *Channel) {
+	shard := r.shard(room)
+	shard.Lock()
+	defer shard.Unlock()
+
+	roomChannels, ok := shard.channels[room]
+	if !ok {
+		return
+	}
+
+	if _, ok := roomChannels[old]; ok {
+		delete(roomChannels, old)
+		roomChannels[c] = struct{}{}
+	}
+}
+
+// amount returns an amount of channels joined to the room
+func (r *roomRegistry) amount(room string) int {
+	shard := r.shard(room)
+	shard.RLock()
+	defer shard.RUnlock()
+	return len(shard.channels[room])
+}
+
+// list returns a snapshot of channels joined to the room
+func (r *roomRegistry) list(room string) []*Channel {
+	shard := r.shard(room)
+	shard.RLock()
+	defer shard.RUnlock()
+
+	roomChannels := shard.channels[room]
+	roomChannelsCopy := make([]*Channel, 0, len(roomChannels))
+	for channel := range roomChannels {
+		roomChannelsCopy = append(roomChannelsCopy, channel)
+	}
+	return roomChannelsCopy
+}
+
+// count returns an amount of rooms with at least one joined channel
+func (r *roomRegistry) count() int {
+	n := 0
+	for i := range r.shards {
+		r.shards[i].RLock()
+		n += len(r.shards[i].channels)
+		r.shards[i].RUnlock()
+	}
+	return n
+}
+
+// sidShard is a part of sids registry guarded by it's own lock
+type sidShard struct {
+	sync.RWMutex
+	channels map[string]*Channel // maps channel id to channel
+}
+
+// sidRegistry maps channel ids to channels, spread over the shards by id
+type sidRegistry struct {
+	shards [registryShardsCount]sidShard
+}
+
+// newSidRegistry returns an initialized sids registry
+func newSidRegistry() *sidRegistry {
+	r := &sidRegistry{}
+	for i := range r.shards {
+		r.shards[i].channels = make(map[string]*Channel)
+	}
+	return r
+}
+
+// shard returns a shard holding the given sid
+func (r *sidRegistry) shard(sid string) *sidShard { return &r.shards[shardIndex(sid)] }
+
+// set channel c for the sid
+func (r *sidRegistry) set(sid string, c *Channel) {
+	shard := r.shard(sid)
+	shard.Lock()
+	shard.channels[sid] = c
+	shard.Unlock()
+}
+
+// setIfAbsent sets channel c for the sid if it's not registered yet, returns false otherwise
+func (r *sidRegistry) setIfAbsent(sid string, c *Channel) bool {
+	shard := r.shard(sid)
+	shard.Lock()
+	defer shard.Unlock()
+	if _, exists := shard.channels[sid]; exists {
+		return false
+	}
+	shard.channels[sid] = c
+	return true
+}
+
+// get returns a channel by the sid, the second value is false if it's not found
+func (r *sidRegistry) get(sid string) (*Channel, bool) {
+	shard := r.shard(sid)
+	shard.RLock()
+	defer shard.RUnlock()
+	c, ok := shard.channels[sid]
+	return c, ok
+}
+
+// delete the sid if it still points to channel c
+func (r *sidRegistry) delete(sid string, c *Channel) {
+	shard := r.shard(sid)
+	shard.Lock()
+	defer shard.Unlock()
+	if shard.channels[sid] == c {
+		delete(shard.channels, sid)
+	}
+}
+
+// list returns a snapshot of all registered channels
+func (r *sidRegistry) list() []*Channel {
+	channels := make([]*Channel, 0)
+	for i := range r.shards {
+		r.shards[i].RLock()
+		for _, c := range r.shards[i].channels {
+			channels = append(channels, c)
+		}
+		r.shards[i].RUnlock()
+	}
+	return channels
+}
+
+// count returns an amount of registered channels
+func (r *sidRegistry) count() int {
+	n := 0
+	for i := range r.shards {
+		r.shards[i].RLock()
+		n += len(r.shards[i].channels)
+		r.shards[i].RUnlock()
+	}
+	return n
+}
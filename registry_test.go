@@ -0,0 +1,131 @@
+package gosocketio
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	benchChannelsCount = 100000
+	benchRoomsCount    = 1000
+)
+
+// roomIndex is implemented by rooms registries compared in benchmarks
+type roomIndex interface {
+	join(room string, c *Channel) (joined, created bool)
+	leave(room string, c *Channel) (left, deleted bool)
+	list(room string) []*Channel
+}
+
+// mutexRoomRegistry is the former rooms registry design guarded by a single lock, kept for comparison
+type mutexRoomRegistry struct {
+	sync.RWMutex
+	channels map[string]map[*Channel]struct{}
+}
+
+func newMutexRoomRegistry() *mutexRoomRegistry {
+	return &mutexRoomRegistry{channels: make(map[string]map[*Channel]struct{})}
+}
+
+func (r *mutexRoomRegistry) join(room string, c *Channel) (bool, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	roomChannels, exists := r.channels[room]
+	if !exists {
+		roomChannels = make(map[*Channel]struct{})
+		r.channels[room] = roomChannels
+	}
+	if _, ok := roomChannels[c]; ok {
+		return false, false
+	}
+	roomChannels[c] = struct{}{}
+	return true, !exists
+}
+
+func (r *mutexRoomRegistry) leave(room string, c *Channel) (bool, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	roomChannels, ok := r.channels[room]
+	if !ok {
+		return false, false
+	}
+	if _, ok := roomChannels[c]; !ok {
+		return false, false
+	}
+	delete(roomChannels, c)
+	if len(roomChannels) == 0 {
+		delete(r.channels, room)
+		return true, true
+	}
+	return true, false
+}
+
+func (r *mutexRoomRegistry) list(room string) []*Channel {
+	r.RLock()
+	defer r.RUnlock()
+
+	channels := make([]*Channel, 0, len(r.channels[room]))
+	for c := range r.channels[room] {
+		channels = append(channels, c)
+	}
+	return channels
+}
+
+// benchRooms returns room names and channels, the channels are joined to rooms of r evenly
+func benchRooms(r roomIndex) ([]string, []*Channel) {
+	rooms := make([]string, benchRoomsCount)
+	for i := range rooms {
+		rooms[i] = "room" + strconv.Itoa(i)
+	}
+
+	channels := make([]*Channel, benchChannelsCount)
+	for i := range channels {
+		channels[i] = &Channel{}
+		r.join(rooms[i%benchRoomsCount], channels[i])
+	}
+	return rooms, channels
+}
+
+// benchmarkRoomChurn runs concurrent joins and leaves on different rooms of r,
+// every broadcastEvery operation takes a broadcast snapshot instead if it's not zero
+func benchmarkRoomChurn(b *testing.B, r roomIndex, broadcastEvery int) {
+	rooms, channels := benchRooms(r)
+	var workers uint64
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		// each worker walks its own sequence of channels so the counter doesn't contend itself
+		i := int(atomic.AddUint64(&workers, 1)) * 7919
+		for pb.Next() {
+			i++
+			c, room := channels[i%benchChannelsCount], rooms[i%benchRoomsCount]
+			if broadcastEvery > 0 && i%broadcastEvery == 0 {
+				for range r.list(room) {
+				}
+				continue
+			}
+			other := rooms[(i+1)%benchRoomsCount]
+			r.join(other, c)
+			r.leave(other, c)
+		}
+	})
+}
+
+func BenchmarkRoomRegistryChurn(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) { benchmarkRoomChurn(b, newRoomRegistry(), 10) })
+	b.Run("single-mutex", func(b *testing.B) { benchmarkRoomChurn(b, newMutexRoomRegistry(), 10) })
+}
+
+func BenchmarkRoomRegistryBroadcast(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) { benchmarkRoomChurn(b, newRoomRegistry(), 2) })
+	b.Run("single-mutex", func(b *testing.B) { benchmarkRoomChurn(b, newMutexRoomRegistry(), 2) })
+}
+
+func BenchmarkRoomRegistryJoinLeave(b *testing.B) {
+	b.Run("sharded", func(b *testing.B) { benchmarkRoomChurn(b, newRoomRegistry(), 0) })
+	b.Run("single-mutex", func(b *testing.B) { benchmarkRoomChurn(b, newMutexRoomRegistry(), 0) })
+}
@@ -1,15 +1,10 @@
 package gosocketio
 
 import (
-	"bytes"
-	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
-	"math/rand"
+	"net"
 	"net/http"
-	"sync"
 	"time"
 
 	"github.com/mtfelian/golang-socketio/logging"
@@ -27,12 +22,28 @@ type Server struct {
 	*event
 	http.Handler
 
-	channels   map[string]map[*Channel]struct{} // maps room name to map of channels to an empty struct
-	rooms      map[*Channel]map[string]struct{} // maps channel to map of room names to an empty struct
-	channelsMu sync.RWMutex
+	rooms *roomRegistry // maps room name to joined channels
+	sids  *sidRegistry  // maps channel id to channel
+	users *userRegistry
+	hooks roomHooks
+	auth  authHooks
 
-	sids   map[string]*Channel // maps channel id to channel
-	sidsMu sync.RWMutex
+	presence *presenceTracker
+
+	// PresenceDebounce is an interval a user's leave from a room is delayed for, so short reconnects
+	// don't produce presence:leave and presence:join events
+	PresenceDebounce time.Duration
+
+	// SidGenerator generates session ids, DefaultSidGenerator is used if it's nil
+	SidGenerator SidGenerator
+
+	// Limits restricts new connections, it should be set before serving
+	Limits  Limits
+	limiter *connectionLimiter
+
+	// ForwardingHeader is a header the trusted proxies report the client IP in, it should be set before serving
+	ForwardingHeader ForwardingHeader
+	trustedProxies   []*net.IPNet // proxies trusted to report the client IP
 
 	websocket *transport.WebsocketTransport
 	polling   *transport.PollingTransport
@@ -43,24 +54,26 @@ func NewServer() *Server {
 	s := &Server{
 		websocket: transport.DefaultWebsocketTransport(),
 		polling:   transport.DefaultPollingTransport(),
-		channels:  make(map[string]map[*Channel]struct{}),
-		rooms:     make(map[*Channel]map[string]struct{}),
-		sids:      make(map[string]*Channel),
+		rooms:     newRoomRegistry(),
+		sids:      newSidRegistry(),
+		users:     newUserRegistry(),
+		limiter:   newConnectionLimiter(),
+
+		PresenceDebounce: DefaultPresenceDebounce,
+		SidGenerator:     DefaultSidGenerator,
 		event: &event{
 			onConnection:    onConnection,
 			onDisconnection: onDisconnection,
 		},
 	}
 	s.event.init()
+	s.presence = newPresenceTracker(s)
 	return s
 }
 
 // GetChannel by it's sid
 func (s *Server) GetChannel(sid string) (*Channel, error) {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
-
-	c, ok := s.sids[sid]
+	c, ok := s.sids.get(sid)
 	if !ok {
 		return nil, ErrorConnectionNotFound
 	}
@@ -69,44 +82,14 @@ func (s *Server) GetChannel(sid string) (*Channel, error) {
 }
 
 // Get amount of channels, joined to given room, using server
-func (s *Server) Amount(room string) int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	roomChannels, _ := s.channels[room]
-	return len(roomChannels)
-}
+func (s *Server) Amount(room string) int { return s.rooms.amount(room) }
 
 // List returns a list of channels joined to the given room, using server
-func (s *Server) List(room string) []*Channel {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return []*Channel{}
-	}
-
-	i := 0
-	roomChannelsCopy := make([]*Channel, len(roomChannels))
-	for channel := range roomChannels {
-		roomChannelsCopy[i] = channel
-		i++
-	}
-
-	return roomChannelsCopy
-}
+func (s *Server) List(room string) []*Channel { return s.rooms.list(room) }
 
 // BroadcastTo the the given room an handler with payload, using server
 func (s *Server) BroadcastTo(room, name string, payload interface{}) {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-
-	roomChannels, ok := s.channels[room]
-	if !ok {
-		return
-	}
-
-	for cn := range roomChannels {
+	for _, cn := range s.rooms.list(room) {
 		if cn.IsAlive() {
 			go cn.Emit(name, payload)
 		}
@@ -115,10 +98,7 @@ func (s *Server) BroadcastTo(room, name string, payload interface{}) {
 
 // Broadcast to all clients
 func (s *Server) BroadcastToAll(method string, payload interface{}) {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
-
-	for _, cn := range s.sids {
+	for _, cn := range s.sids.list() {
 		if cn.IsAlive() {
 			go cn.Emit(method, payload)
 		}
@@ -126,37 +106,16 @@ func (s *Server) BroadcastToAll(method string, payload interface{}) {
 }
 
 // onConnection fires on connection and on connection upgrade
-func onConnection(c *Channel) {
-	c.server.sidsMu.Lock()
-	c.server.sids[c.Id()] = c
-	c.server.sidsMu.Unlock()
-}
+func onConnection(c *Channel) { c.server.sids.set(c.Id(), c) }
 
 // onDisconnection fires on disconnection
 func onDisconnection(c *Channel) {
-	c.server.channelsMu.Lock()
-	defer c.server.channelsMu.Unlock()
-
-	defer func() {
-		c.server.sidsMu.Lock()
-		delete(c.server.sids, c.Id())
-		c.server.sidsMu.Unlock()
-	}()
-
-	_, ok := c.server.rooms[c]
-	if !ok {
-		return
-	}
-
-	for room := range c.server.rooms[c] {
-		if curRoom, ok := c.server.channels[room]; ok {
-			delete(curRoom, c)
-			if len(curRoom) == 0 {
-				delete(c.server.channels, room)
-			}
-		}
+	c.leaveAll()
+	if uid, _ := c.User(); uid != "" {
+		c.server.users.leave(uid, c)
 	}
-	delete(c.server.rooms, c)
+	c.server.sids.delete(c.Id(), c)
+	c.server.limiter.release(c.limitIP)
 }
 
 // sendOpenSequence to the given channel c
@@ -166,44 +125,49 @@ func (s *Server) sendOpenSequence(c *Channel) {
 		panic(err)
 	}
 	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeOpen, Args: string(jsonHdr)})
-	c.outC <- protocol.MustEncode(&protocol.Message{Type: protocol.MessageTypeEmpty})
+	connect, err := s.parser.Encode(&protocol.Message{Type: protocol.MessageTypeEmpty})
+	if err != nil {
+		panic(err)
+	}
+	c.outC <- connect
 }
 
-// setupEventLoop for the given connection conn on the given address with HTTP header
-func (s *Server) setupEventLoop(conn transport.Connection, address string, header http.Header) {
+// setupEventLoop for the given connection conn established with the request r from the client ip
+func (s *Server) setupEventLoop(conn transport.Connection, r *http.Request, ip string) error {
 	interval, timeout := conn.PingParams()
 	connHeader := connectionHeader{
-		Sid: func(s string) string {
-			hash := fmt.Sprintf("%s %s %b %b", s, time.Now(), rand.Uint32(), rand.Uint32())
-			buf, sum := bytes.NewBuffer(nil), md5.Sum([]byte(hash))
-			encoder := base64.NewEncoder(base64.URLEncoding, buf)
-			encoder.Write(sum[:])
-			encoder.Close()
-			return buf.String()[:20]
-		}(address),
 		Upgrades:     []string{"websocket"},
 		PingInterval: int(interval / time.Millisecond),
 		PingTimeout:  int(timeout / time.Millisecond),
+		MaxPayload:   s.polling.MaxPayload,
 	}
 
-	c := &Channel{conn: conn, address: address, header: header, server: s, connHeader: connHeader}
+	c := &Channel{conn: conn, address: r.RemoteAddr, header: r.Header, server: s, events: s.event, connHeader: connHeader}
+	c.limitIP, c.handshake = ip, newHandshake(r)
 	c.init()
+	if err := s.reserveSid(c); err != nil {
+		logging.Log().Warn("Server.setupEventLoop() failed to generate sid:", err)
+		c.cancel()
+		return err
+	}
 
 	switch conn.(type) {
 	case *transport.PollingConnection:
-		conn.(*transport.PollingConnection).Transport.SetSid(connHeader.Sid, conn)
+		conn.(*transport.PollingConnection).Transport.SetSid(c.Id(), conn)
 	}
 
 	s.sendOpenSequence(c)
 
 	go c.inLoop(s.event)
 	go c.outLoop(s.event)
+	go c.heartbeatLoop(s.event)
 
-	s.callHandler(c, OnConnection)
+	s.callHandler(c, OnConnection, nil)
+	return nil
 }
 
 // upgradeEventLoop at transport upgrade
-func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string, header http.Header, sid string) {
+func (s *Server) upgradeEventLoop(conn transport.Connection, r *http.Request, sid string) {
 	logging.Log().Debug("Server.upgradeEventLoop() fired")
 
 	pollingChannel, err := s.GetChannel(sid)
@@ -221,12 +185,14 @@ func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string,
 		PingTimeout:  int(timeout / time.Millisecond),
 	}
 
-	c := &Channel{conn: conn, address: remoteAddr, header: header, server: s, connHeader: connHeader}
+	c := &Channel{conn: conn, address: r.RemoteAddr, header: r.Header, server: s, events: s.event, connHeader: connHeader}
 	c.init()
+	c.inherit(pollingChannel)
 	logging.Log().Debug("Server.upgradeEventLoop() initialized a new channel")
 
 	go c.inLoop(s.event)
 	go c.outLoop(s.event)
+	go c.heartbeatLoop(s.event)
 
 	logging.Log().Debug("Server.upgradeEventLoop() fired c.inLoop() and c.outLoop() in separate go-routines")
 	onConnection(c)
@@ -236,6 +202,19 @@ func (s *Server) upgradeEventLoop(conn transport.Connection, remoteAddr string,
 	pollingChannel.stub()
 }
 
+// acquireConnection checks the handshake request r against the connection limits, the request is answered
+// if the limits are exceeded and false is returned. Otherwise the returned IP should be released
+// by the connection limiter when the connection closes
+func (s *Server) acquireConnection(w http.ResponseWriter, r *http.Request) (string, bool) {
+	ip := s.clientIP(r)
+	if err := s.limiter.acquire(ip, s.Limits); err != nil {
+		logging.Log().Debug("Server.acquireConnection() rejected the handshake:", err)
+		writeLimitError(w, err)
+		return "", false
+	}
+	return ip, true
+}
+
 // ServeHTTP makes Server to implement http.Handler
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	session, transportName := r.URL.Query().Get("sid"), r.URL.Query().Get("transport")
@@ -253,43 +232,79 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
+		ip, ok := s.acquireConnection(w, r)
+		if !ok {
+			return
+		}
+
+		if err := s.setupEventLoop(conn, r, ip); err != nil {
+			s.limiter.release(ip)
+			transport.WriteError(w, &transport.ProtocolError{Code: transport.ErrorBadRequest.Code, Message: err.Error(),
+				Status: http.StatusInternalServerError})
+			return
+		}
 		logging.Log().Debug("Server.ServeHTTP() created a PollingConnection")
 		conn.(*transport.PollingConnection).PollingWriter(w, r)
 
 	case "websocket":
 		if session != "" {
+			if _, err := s.GetChannel(session); err != nil {
+				transport.WriteError(w, transport.ErrorUnknownSid)
+				return
+			}
+
 			logging.Log().Debug("Server.ServeHTTP() is firing s.websocket.HandleConnection() for upgrade")
 			conn, err := s.websocket.HandleConnection(w, r)
 			if err != nil {
 				logging.Log().Debug("Server.ServeHTTP() upgrade error:", err)
 				return
 			}
-			s.upgradeEventLoop(conn, r.RemoteAddr, r.Header, session)
+			s.upgradeEventLoop(conn, r, session)
 			logging.Log().Debug("Server.ServeHTTP() upgraded to a WebsocketConnection")
 			return
 		}
 
+		ip, ok := s.acquireConnection(w, r)
+		if !ok {
+			return
+		}
+
 		conn, err := s.websocket.HandleConnection(w, r)
 		if err != nil {
+			s.limiter.release(ip)
 			return
 		}
 
-		s.setupEventLoop(conn, r.RemoteAddr, r.Header)
+		if err := s.setupEventLoop(conn, r, ip); err != nil {
+			s.limiter.release(ip)
+			conn.Close()
+			return
+		}
 		logging.Log().Debug("Server.ServeHTTP() created a WebsocketConnection")
+
+	default:
+		transport.WriteError(w, transport.ErrorUnknownTransport)
 	}
 }
 
-// CountChannels returns an amount of connected channels
-func (s *Server) CountChannels() int {
-	s.sidsMu.RLock()
-	defer s.sidsMu.RUnlock()
-	return len(s.sids)
+// SetCORS sets cross-origin resource sharing policy for both transports, it should be set before serving
+func (s *Server) SetCORS(cors transport.CORS) {
+	s.polling.CORS = cors
+	s.websocket.CORS = cors
 }
 
-// CountRooms returns an amount of rooms with at least one joined channel
-func (s *Server) CountRooms() int {
-	s.channelsMu.RLock()
-	defer s.channelsMu.RUnlock()
-	return len(s.channels)
+// SetMaxPayload sets maximum size of incoming message or polling request body in bytes for both transports,
+// 0 means no limit. It's advertised to clients in the handshake and should be set before serving
+func (s *Server) SetMaxPayload(n int64) {
+	s.polling.MaxPayload = n
+	s.websocket.MaxPayload = n
 }
+
+// CountChannels returns an amount of connected channels
+func (s *Server) CountChannels() int { return s.sids.count() }
+
+// CountPollingSessions returns an amount of live polling sessions
+func (s *Server) CountPollingSessions() int { return s.polling.SessionsCount() }
+
+// CountRooms returns an amount of rooms with at least one joined channel
+func (s *Server) CountRooms() int { return s.rooms.count() }
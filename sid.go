@@ -0,0 +1,47 @@
+package gosocketio
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+const (
+	sidRandomBytes      = 15 // encoded to 20 characters
+	sidGenerateAttempts = 10
+)
+
+var ErrorSidCollision = errors.New("failed to generate unique session id")
+
+// SidGenerator returns a new session id, it should be unpredictable since knowing the sid of polling
+// session allows to hijack it. Collisions with existing sids are checked by the server
+type SidGenerator func() (string, error)
+
+// DefaultSidGenerator returns a random URL-safe session id generated with crypto/rand
+func DefaultSidGenerator() (string, error) {
+	b := make([]byte, sidRandomBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// reserveSid generates a new session id for channel c and registers the channel with it
+func (s *Server) reserveSid(c *Channel) error {
+	generate := s.SidGenerator
+	if generate == nil {
+		generate = DefaultSidGenerator
+	}
+
+	for i := 0; i < sidGenerateAttempts; i++ {
+		sid, err := generate()
+		if err != nil {
+			return err
+		}
+		c.connHeader.Sid = sid
+		if s.sids.setIfAbsent(sid, c) {
+			return nil
+		}
+	}
+	return ErrorSidCollision
+}
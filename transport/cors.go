@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// anyOrigin allows requests from any origin
+const anyOrigin = "*"
+
+var errPreflightRequest = errors.New("preflight request")
+
+// CORS represents a cross-origin resource sharing policy. Requests without Origin header and same origin
+// requests are always allowed, so the zero value allows no cross-origin requests
+type CORS struct {
+	// AllowedOrigins are origins like "https://example.com" allowed to connect, "*" allows any origin
+	AllowedOrigins []string
+	// AllowCredentials allows browsers to send cookies and HTTP authentication with cross-origin requests
+	AllowCredentials bool
+	// AllowedHeaders are request headers allowed in cross-origin polling requests,
+	// headers requested by a preflight request are allowed if it's empty
+	AllowedHeaders []string
+	// MaxAge is a time the preflight response may be cached for, it's not sent if zero
+	MaxAge time.Duration
+	// CheckOrigin overrides AllowedOrigins check if set
+	CheckOrigin func(r *http.Request) bool
+}
+
+// allowed returns true if the request's origin is allowed
+func (c *CORS) allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	if c.CheckOrigin != nil {
+		return c.CheckOrigin(r)
+	}
+
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == anyOrigin || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// handle checks the request's origin and sets CORS headers to w. It answers preflight requests and requests
+// from not allowed origins, and returns an error then, otherwise the request should be served further
+func (c *CORS) handle(w http.ResponseWriter, r *http.Request) error {
+	if !c.allowed(r) {
+		WriteError(w, ErrorForbidden)
+		return ErrorForbidden
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin != "" {
+		// the origin is echoed since "*" is not allowed with credentials
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	if r.Method != http.MethodOptions {
+		return nil
+	}
+
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+	if len(c.AllowedHeaders) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowedHeaders, ", "))
+	} else if headers := r.Header.Get("Access-Control-Request-Headers"); headers != "" {
+		w.Header().Set("Access-Control-Allow-Headers", headers)
+	}
+	if c.MaxAge > 0 {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge/time.Second)))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return errPreflightRequest
+}
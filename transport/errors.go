@@ -0,0 +1,82 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mtfelian/golang-socketio/logging"
+)
+
+// ProtocolError represents an Engine.IO error response
+type ProtocolError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  int    `json:"-"` // HTTP status code of the response
+}
+
+// Engine.IO errors
+var (
+	ErrorUnknownTransport   = &ProtocolError{Code: 0, Message: "Transport unknown", Status: http.StatusBadRequest}
+	ErrorUnknownSid         = &ProtocolError{Code: 1, Message: "Session ID unknown", Status: http.StatusBadRequest}
+	ErrorBadHandshakeMethod = &ProtocolError{Code: 2, Message: "Bad handshake method", Status: http.StatusBadRequest}
+	ErrorBadRequest         = &ProtocolError{Code: 3, Message: "Bad request", Status: http.StatusBadRequest}
+	ErrorForbidden          = &ProtocolError{Code: 4, Message: "Forbidden", Status: http.StatusForbidden}
+
+	// ErrorRequestTooLarge is a bad request with the body exceeding MaxPayload
+	ErrorRequestTooLarge = &ProtocolError{Code: 3, Message: "Payload too large", Status: http.StatusRequestEntityTooLarge}
+)
+
+// protocolErrors maps Engine.IO error codes to errors
+var protocolErrors = map[int]*ProtocolError{
+	ErrorUnknownTransport.Code:   ErrorUnknownTransport,
+	ErrorUnknownSid.Code:         ErrorUnknownSid,
+	ErrorBadHandshakeMethod.Code: ErrorBadHandshakeMethod,
+	ErrorBadRequest.Code:         ErrorBadRequest,
+	ErrorForbidden.Code:          ErrorForbidden,
+}
+
+// Error implements error interface
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("engine.io error %d: %s", e.Code, e.Message)
+}
+
+// Is reports whether target is a *ProtocolError with the same code
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code
+}
+
+// WriteError writes Engine.IO error response e to w
+func WriteError(w http.ResponseWriter, e *ProtocolError) {
+	logging.Log().Debug("WriteError() fired with:", e)
+	body, err := json.Marshal(e)
+	if err != nil {
+		http.Error(w, e.Message, e.Status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	w.Write(body)
+}
+
+// responseError returns an error for the non-OK HTTP response, Engine.IO error responses are returned
+// as *ProtocolError, matching the known error of the same code with errors.Is. The response body is closed
+func responseError(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	e := &ProtocolError{Status: resp.StatusCode}
+	if err := json.Unmarshal(body, e); err != nil || e.Message == "" {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	if known, ok := protocolErrors[e.Code]; ok && known.Message == e.Message && known.Status == e.Status {
+		return known
+	}
+	return e
+}
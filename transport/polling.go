@@ -1,7 +1,9 @@
 package transport
 
 import (
+	"encoding/base64"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -22,18 +24,37 @@ const (
 	StopMessage     = "stop"
 	UpgradedMessage = "upgrade"
 	noError         = "0"
-
-	hijackingNotSupported = "webserver doesn't support hijacking"
 )
 
 var (
-	errGetMessageTimeout       = errors.New("timeout waiting for the message")
-	errReceivedConnectionClose = errors.New("received connection close")
-	errWriteMessageTimeout     = errors.New("timeout waiting for write")
+	errWriteMessageTimeout = errors.New("timeout waiting for write")
 )
 
-// withLength returns s as a message with length
-func withLength(m string) string { return fmt.Sprintf("%d:%s", len(m), m) }
+// base64MessagePrefix prefixes base64 encoded binary messages in polling payloads
+const base64MessagePrefix = "b4"
+
+// withLength returns s as a message with length, binary message is encoded to base64
+func withLength(m string) string {
+	if strings.HasPrefix(m, protocol.BinaryMessagePrefix) {
+		m = base64MessagePrefix + base64.StdEncoding.EncodeToString([]byte(m[len(protocol.BinaryMessagePrefix):]))
+	}
+	return fmt.Sprintf("%d:%s", len(m), m)
+}
+
+// withoutLength returns a message from the payload p, base64 encoded binary message is decoded
+func withoutLength(p string) string {
+	m := p[strings.Index(p, ":")+1:]
+	if !strings.HasPrefix(m, base64MessagePrefix) {
+		return m
+	}
+
+	b, err := base64.StdEncoding.DecodeString(m[len(base64MessagePrefix):])
+	if err != nil {
+		logging.Log().Debug("withoutLength() error base64.DecodeString():", err)
+		return m
+	}
+	return protocol.BinaryMessagePrefix + string(b)
+}
 
 // PollingTransportParams represents XHR polling transport params
 type PollingTransportParams struct {
@@ -46,7 +67,49 @@ type PollingConnection struct {
 	eventsInC  chan string
 	eventsOutC chan string
 	errors     chan string
+	failC      chan error
 	sessionID  string
+
+	closedC   chan struct{} // closed when the connection is closed or expired
+	closeOnce sync.Once
+	closeErr  error // returned by GetMessage after the connection is closed
+
+	lastSeen   time.Time // time the last request was started or finished
+	requests   int       // amount of requests being served
+	activityMu sync.Mutex
+}
+
+// begin marks the start of serving a request
+func (polling *PollingConnection) begin() {
+	polling.activityMu.Lock()
+	polling.lastSeen = time.Now()
+	polling.requests++
+	polling.activityMu.Unlock()
+}
+
+// end marks the end of serving a request
+func (polling *PollingConnection) end() {
+	polling.activityMu.Lock()
+	polling.lastSeen = time.Now()
+	polling.requests--
+	polling.activityMu.Unlock()
+}
+
+// idle returns true if no requests are being served and no requests were served for the duration d
+func (polling *PollingConnection) idle(d time.Duration) bool {
+	polling.activityMu.Lock()
+	defer polling.activityMu.Unlock()
+	return polling.requests == 0 && time.Since(polling.lastSeen) > d
+}
+
+// closeWith marks the connection closed, so GetMessage and WriteMessage return err, and deletes the session
+func (polling *PollingConnection) closeWith(err error) {
+	polling.closeOnce.Do(func() {
+		logging.Log().Debug("PollingConnection.closeWith() fired for session:", polling.sessionID)
+		polling.closeErr = err
+		close(polling.closedC)
+		polling.Transport.sessions.Delete(polling.sessionID, polling)
+	})
 }
 
 // GetMessage waits for incoming message from the connection
@@ -54,25 +117,39 @@ func (polling *PollingConnection) GetMessage() (string, error) {
 	select {
 	case <-time.After(polling.Transport.ReceiveTimeout):
 		logging.Log().Debug("PollingConnection.GetMessage() timed out")
-		return "", errGetMessageTimeout
+		return "", ErrorReceiveTimeout
 	case m := <-polling.eventsInC:
 		logging.Log().Debug("PollingConnection.GetMessage() received:", m)
 		if m == protocol.MessageClose {
 			logging.Log().Debug("PollingConnection.GetMessage() received connection close")
-			return "", errReceivedConnectionClose
+			return "", ErrorConnectionClosed
 		}
 		return m, nil
+	case err := <-polling.failC:
+		logging.Log().Debug("PollingConnection.GetMessage() failed with:", err)
+		return "", err
+	case <-polling.closedC:
+		return "", polling.closeErr
 	}
 }
 
 // WriteMessage to the connection
 func (polling *PollingConnection) WriteMessage(message string) error {
 	logging.Log().Debug("PollingConnection.WriteMessage() fired with:", message)
-	polling.eventsOutC <- message
+	select {
+	case polling.eventsOutC <- message:
+	case <-time.After(polling.Transport.SendTimeout):
+		return errWriteMessageTimeout
+	case <-polling.closedC:
+		return polling.closeErr
+	}
+
 	logging.Log().Debug("PollingConnection.WriteMessage() written to eventsOutC:", message)
 	select {
 	case <-time.After(polling.Transport.SendTimeout):
 		return errWriteMessageTimeout
+	case <-polling.closedC:
+		return polling.closeErr
 	case errString := <-polling.errors:
 		if errString != noError {
 			logging.Log().Debug("PollingConnection.WriteMessage() failed to write with err:", errString)
@@ -86,7 +163,7 @@ func (polling *PollingConnection) WriteMessage(message string) error {
 func (polling *PollingConnection) Close() error {
 	logging.Log().Debug("PollingConnection.Close() fired for session:", polling.sessionID)
 	err := polling.WriteMessage(protocol.MessageBlank)
-	polling.Transport.sessions.Delete(polling.sessionID)
+	polling.closeWith(ErrorConnectionClosed)
 	return err
 }
 
@@ -98,7 +175,9 @@ func (polling *PollingConnection) PingParams() (time.Duration, time.Duration) {
 // sessions describes sessions needed for identifying polling connections with socket.io connections
 type sessions struct {
 	sync.Mutex
-	m map[string]*PollingConnection
+	m        map[string]*PollingConnection
+	sweeping bool   // true while the idle sessions sweeping goroutine is running
+	expired  uint64 // amount of sessions expired by sweeping
 }
 
 // Set sets sessionID to the given connection
@@ -109,12 +188,40 @@ func (s *sessions) Set(sessionID string, conn *PollingConnection) {
 	s.m[sessionID] = conn
 }
 
-// Delete the sessionID
-func (s *sessions) Delete(sessionID string) {
+// Delete the sessionID if it still belongs to the given connection
+func (s *sessions) Delete(sessionID string, conn *PollingConnection) {
 	logging.Log().Debug("sessions.Delete() fired with:", sessionID)
 	s.Lock()
 	defer s.Unlock()
-	delete(s.m, sessionID)
+	if s.m[sessionID] == conn {
+		delete(s.m, sessionID)
+	}
+}
+
+// Count returns an amount of sessions
+func (s *sessions) Count() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.m)
+}
+
+// idle returns connections idle for the duration d, or false if there are no sessions
+func (s *sessions) idle(d time.Duration) ([]*PollingConnection, bool) {
+	s.Lock()
+	defer s.Unlock()
+	if len(s.m) == 0 {
+		s.sweeping = false
+		return nil, false
+	}
+
+	var idle []*PollingConnection
+	for _, conn := range s.m {
+		if conn.idle(d) {
+			idle = append(idle, conn)
+		}
+	}
+	s.expired += uint64(len(idle))
+	return idle, true
 }
 
 // Get returns polling connection if it exists, otherwise returns nil
@@ -130,8 +237,10 @@ type PollingTransport struct {
 	PingTimeout    time.Duration
 	ReceiveTimeout time.Duration
 	SendTimeout    time.Duration
+	MaxPayload     int64 // maximum size of POST request body in bytes, 0 means no limit
 
 	Headers  http.Header
+	CORS     CORS
 	sessions sessions
 }
 
@@ -142,52 +251,135 @@ func (t *PollingTransport) Connect(url string) (Connection, error) {
 
 // HandleConnection returns a pointer to a new Connection
 func (t *PollingTransport) HandleConnection(w http.ResponseWriter, r *http.Request) (Connection, error) {
+	if err := t.CORS.handle(w, r); err != nil {
+		return nil, err
+	}
+
+	if r.Method != http.MethodGet {
+		WriteError(w, ErrorBadHandshakeMethod)
+		return nil, ErrorBadHandshakeMethod
+	}
+
 	return &PollingConnection{
 		Transport:  t,
 		eventsInC:  make(chan string),
 		eventsOutC: make(chan string),
 		errors:     make(chan string),
+		failC:      make(chan error, 1),
+		closedC:    make(chan struct{}),
+		lastSeen:   time.Now(),
 	}, nil
 }
 
 // SetSid to the given sessionID and connection
 func (t *PollingTransport) SetSid(sessionID string, connection Connection) {
-	t.sessions.Set(sessionID, connection.(*PollingConnection))
 	connection.(*PollingConnection).sessionID = sessionID
+	t.sessions.Set(sessionID, connection.(*PollingConnection))
+
+	t.sessions.Lock()
+	if !t.sessions.sweeping {
+		t.sessions.sweeping = true
+		go t.sweep()
+	}
+	t.sessions.Unlock()
+}
+
+// sweep expires sessions without requests within ping interval and ping timeout while there are any sessions
+func (t *PollingTransport) sweep() {
+	for {
+		time.Sleep(t.PingTimeout)
+
+		idle, ok := t.sessions.idle(t.PingInterval + t.PingTimeout)
+		if !ok {
+			return
+		}
+		for _, conn := range idle {
+			logging.Log().Debug("PollingTransport.sweep() expires session:", conn.sessionID)
+			conn.closeWith(ErrorReceiveTimeout)
+		}
+	}
+}
+
+// SessionsCount returns an amount of live polling sessions
+func (t *PollingTransport) SessionsCount() int { return t.sessions.Count() }
+
+// ExpiredSessionsCount returns an amount of sessions expired since the transport was created
+func (t *PollingTransport) ExpiredSessionsCount() uint64 {
+	t.sessions.Lock()
+	defer t.sessions.Unlock()
+	return t.sessions.expired
 }
 
 // Serve is for receiving messages from client, simple decoding also here
 func (t *PollingTransport) Serve(w http.ResponseWriter, r *http.Request) {
+	if err := t.CORS.handle(w, r); err != nil {
+		return
+	}
+
 	sessionId := r.URL.Query().Get("sid")
 	conn := t.sessions.Get(sessionId)
 	if conn == nil {
+		WriteError(w, ErrorUnknownSid)
 		return
 	}
 
+	conn.begin()
+	defer conn.end()
+
 	switch r.Method {
 	case http.MethodGet:
 		logging.Log().Debug("PollingTransport.Serve() is serving GET request")
 		conn.PollingWriter(w, r)
 	case http.MethodPost:
-		bodyBytes, err := ioutil.ReadAll(r.Body)
+		reader := io.Reader(r.Body)
+		if t.MaxPayload > 0 {
+			if r.ContentLength > t.MaxPayload {
+				t.payloadTooLarge(w, conn)
+				return
+			}
+			reader = http.MaxBytesReader(w, r.Body, t.MaxPayload)
+		}
+
+		bodyBytes, err := ioutil.ReadAll(reader)
 		r.Body.Close()
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			t.payloadTooLarge(w, conn)
+			return
+		}
 		if err != nil {
 			logging.Log().Debug("PollingTransport.Serve() error ioutil.ReadAll():", err)
+			WriteError(w, ErrorBadRequest)
 			return
 		}
 
 		bodyString := string(bodyBytes)
 		logging.Log().Debug("PollingTransport.Serve() POST bodyString before split:", bodyString)
-		index := strings.Index(bodyString, ":")
-		body := bodyString[index+1:]
+		body := withoutLength(bodyString)
 
 		setHeaders(w)
 
 		logging.Log().Debug("PollingTransport.Serve() POST body:", body)
 		w.Write([]byte("ok"))
 		logging.Log().Debug("PollingTransport.Serve() written POST response")
-		conn.eventsInC <- body
-		logging.Log().Debug("PollingTransport.Serve() sent to eventsInC")
+		select {
+		case conn.eventsInC <- body:
+			logging.Log().Debug("PollingTransport.Serve() sent to eventsInC")
+		case <-conn.closedC:
+			logging.Log().Debug("PollingTransport.Serve() connection is closed")
+		}
+	default:
+		WriteError(w, ErrorBadRequest)
+	}
+}
+
+// payloadTooLarge responds to the too large POST request and fails the connection
+func (t *PollingTransport) payloadTooLarge(w http.ResponseWriter, conn *PollingConnection) {
+	logging.Log().Debug("PollingTransport.Serve() POST payload too large for session:", conn.sessionID)
+	WriteError(w, ErrorRequestTooLarge)
+	select {
+	case conn.failC <- ErrorPayloadTooLarge:
+	default:
 	}
 }
 
@@ -198,6 +390,7 @@ func DefaultPollingTransport() *PollingTransport {
 		PingTimeout:    PlDefaultPingTimeout,
 		ReceiveTimeout: PlDefaultReceiveTimeout,
 		SendTimeout:    PlDefaultSendTimeout,
+		MaxPayload:     DefaultMaxPayload,
 		sessions: sessions{
 			Mutex: sync.Mutex{},
 			m:     map[string]*PollingConnection{},
@@ -212,49 +405,42 @@ func (polling *PollingConnection) PollingWriter(w http.ResponseWriter, r *http.R
 	select {
 	case <-time.After(polling.Transport.SendTimeout):
 		logging.Log().Debug("PollingTransport.PollingWriter() timed out")
-		polling.errors <- noError
+		w.Write([]byte(withLength(protocol.MessageBlank)))
+	case <-polling.closedC:
+		logging.Log().Debug("PollingTransport.PollingWriter() connection is closed")
+		w.Write([]byte(withLength(protocol.MessageClose)))
 	case message := <-polling.eventsOutC:
 		logging.Log().Debug("PollingTransport.PollingWriter() prepares to write message:", message)
-		message = withLength(message)
-		if message == withLength(protocol.MessageBlank) {
-			logging.Log().Debug("PollingTransport.PollingWriter() writing 1:6")
-
-			hj, ok := w.(http.Hijacker)
-			if !ok {
-				http.Error(w, hijackingNotSupported, http.StatusInternalServerError)
-				return
-			}
-
-			conn, buffer, err := hj.Hijack()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
-				return
-			}
+		_, err := w.Write([]byte(withLength(message)))
+		logging.Log().Debug("PollingTransport.PollingWriter() written message:", message)
+		if err != nil {
+			logging.Log().Debug("PollingTransport.PollingWriter() failed to write message with err:", err)
+			polling.result(err.Error())
+			return
+		}
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		polling.result(noError)
 
-			defer conn.Close()
-
-			buffer.WriteString("HTTP/1.1 200 OK\r\n" +
-				"Cache-Control: no-cache, private\r\n" +
-				"Content-Length: 3\r\n" +
-				"Date: Mon, 24 Nov 2016 10:21:21 GMT\r\n\r\n")
-			buffer.WriteString(withLength(protocol.MessageBlank))
-			buffer.Flush()
-			logging.Log().Debug("PollingTransport.PollingWriter() hijack returns")
-			polling.errors <- noError
-			polling.eventsInC <- StopMessage
-		} else {
-			_, err := w.Write([]byte(message))
-			logging.Log().Debug("PollingTransport.PollingWriter() written message:", message)
-			if err != nil {
-				logging.Log().Debug("PollingTransport.PollingWriter() failed to write message with err:", err)
-				polling.errors <- err.Error()
-				return
+		// noop packet is the last one written at the upgrade or closing, stop the incoming loop then
+		if message == protocol.MessageBlank {
+			select {
+			case polling.eventsInC <- StopMessage:
+			case <-polling.closedC:
 			}
-			polling.errors <- noError
 		}
 	}
 }
 
+// result reports the result of writing the message to WriteMessage unless the connection is closed
+func (polling *PollingConnection) result(errString string) {
+	select {
+	case polling.errors <- errString:
+	case <-polling.closedC:
+	}
+}
+
 // setHeaders into w
 func setHeaders(w http.ResponseWriter) {
 	// We are going to return JSON no matter what:
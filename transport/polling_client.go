@@ -1,9 +1,10 @@
 package transport
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -24,54 +25,80 @@ type PollingClientConnection struct {
 	transport *PollingClientTransport
 	client    *http.Client
 	url       string
+	header    http.Header
 	sid       string
 }
 
-// GetMessage performs a GET request to wait for the following message
-func (polling *PollingClientConnection) GetMessage() (string, error) {
-	logging.Log().Debug("PollingConnection.GetMessage() fired")
+// request performs the HTTP request to the connection url, non-OK responses are returned as errors
+func (polling *PollingClientConnection) request(ctx context.Context, method string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, polling.url, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	for key, values := range polling.header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := polling.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp)
+	}
+	return resp, nil
+}
 
-	resp, err := polling.client.Get(polling.url)
+// read performs a GET request and returns the message received
+func (polling *PollingClientConnection) read(ctx context.Context) (string, error) {
+	resp, err := polling.request(ctx, http.MethodGet, nil)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.GetMessage() error polling.client.Get():", err)
+		logging.Log().Debug("PollingConnection.read() error polling.request():", err)
 		return "", err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
-		logging.Log().Debug("PollingConnection.GetMessage() error ioutil.ReadAll():", err)
+		logging.Log().Debug("PollingConnection.read() error ioutil.ReadAll():", err)
 		return "", err
 	}
 
 	bodyString := string(bodyBytes)
-	logging.Log().Debug("PollingConnection.GetMessage() bodyString:", bodyString)
-	index := strings.Index(bodyString, ":")
+	logging.Log().Debug("PollingConnection.read() bodyString:", bodyString)
+	return withoutLength(bodyString), nil
+}
 
-	body := bodyString[index+1:]
-	return body, nil
+// GetMessage performs a GET request to wait for the following message
+func (polling *PollingClientConnection) GetMessage() (string, error) {
+	logging.Log().Debug("PollingConnection.GetMessage() fired")
+	return polling.read(context.Background())
 }
 
 // WriteMessage performs a POST request to send a message to server
 func (polling *PollingClientConnection) WriteMessage(m string) error {
 	mWrite := withLength(m)
 	logging.Log().Debug("PollingConnection.WriteMessage() fired, msgToWrite:", mWrite)
-	mJSON := []byte(mWrite)
 
-	resp, err := polling.client.Post(polling.url, "application/json", bytes.NewBuffer(mJSON))
+	resp, err := polling.request(context.Background(), http.MethodPost, strings.NewReader(mWrite))
 	if err != nil {
-		logging.Log().Debug("PollingConnection.WriteMessage() error polling.client.Post():", err)
+		logging.Log().Debug("PollingConnection.WriteMessage() error polling.request():", err)
 		return err
 	}
 
 	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
 	if err != nil {
 		logging.Log().Debug("PollingConnection.WriteMessage() error ioutil.ReadAll():", err)
 		return err
 	}
 
-	resp.Body.Close()
-	bodyString := string(bodyBytes)
-	if bodyString != "ok" {
+	if string(bodyBytes) != "ok" {
 		return errResponseIsNotOK
 	}
 
@@ -120,33 +147,40 @@ type openSequence struct {
 
 // Connect to server, perform 3 HTTP requests in connecting sequence
 func (t *PollingClientTransport) Connect(url string) (Connection, error) {
-	polling := &PollingClientConnection{transport: t, client: &http.Client{}, url: url}
+	return t.ConnectWith(url, ConnectOptions{})
+}
 
-	resp, err := polling.client.Get(polling.url)
-	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error polling.client.Get() 1:", err)
-		return nil, err
+// ConnectWith connects to server with options, the timeout limits the connecting sequence requests
+func (t *PollingClientTransport) ConnectWith(url string, opts ConnectOptions) (Connection, error) {
+	polling := &PollingClientConnection{
+		transport: t,
+		client:    &http.Client{},
+		url:       url,
+		header:    mergeHeaders(t.Headers, opts.Header),
+	}
+	if opts.TLSConfig != nil {
+		polling.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: opts.TLSConfig}
 	}
 
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	ctx := context.Background()
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	body, err := polling.read(ctx)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error ioutil.ReadAll() 1:", err)
+		logging.Log().Debug("PollingConnection.Connect() error polling.read() 1:", err)
 		return nil, err
 	}
 
-	resp.Body.Close()
-	bodyString := string(bodyBytes)
-	logging.Log().Debug("PollingConnection.Connect() bodyString 1:", bodyString)
-
-	body := bodyString[strings.Index(bodyString, ":")+1:]
-	if string(body[0]) != protocol.MessageOpen {
+	if !strings.HasPrefix(body, protocol.MessageOpen) {
 		return nil, errAnswerNotOpenSequence
 	}
 
-	bodyBytes2 := []byte(body[1:])
 	var openSequence openSequence
-
-	if err := json.Unmarshal(bodyBytes2, &openSequence); err != nil {
+	if err := json.Unmarshal([]byte(body[len(protocol.MessageOpen):]), &openSequence); err != nil {
 		logging.Log().Debug("PollingConnection.Connect() error json.Unmarshal() 1:", err)
 		return nil, err
 	}
@@ -154,24 +188,14 @@ func (t *PollingClientTransport) Connect(url string) (Connection, error) {
 	polling.url += "&sid=" + openSequence.Sid
 	logging.Log().Debug("PollingConnection.Connect() polling.url 1:", polling.url)
 
-	resp, err = polling.client.Get(polling.url)
-	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error plc.client.Get() 2:", err)
-		return nil, err
-	}
-
-	bodyBytes, err = ioutil.ReadAll(resp.Body)
+	body, err = polling.read(ctx)
 	if err != nil {
-		logging.Log().Debug("PollingConnection.Connect() error ioutil.ReadAll() 2:", err)
+		logging.Log().Debug("PollingConnection.Connect() error polling.read() 2:", err)
 		return nil, err
 	}
 
-	resp.Body.Close()
-	bodyString = string(bodyBytes)
-	logging.Log().Debug("PollingConnection.Connect() bodyString 2:", bodyString)
-	body = bodyString[strings.Index(bodyString, ":")+1:]
-
-	if body != protocol.MessageEmpty {
+	// connect packet is binary when the binary parser is used
+	if body != protocol.MessageEmpty && !strings.HasPrefix(body, protocol.BinaryMessagePrefix) {
 		return nil, errAnswerNotOpenMessage
 	}
 
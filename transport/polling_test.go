@@ -0,0 +1,84 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestSession returns a polling connection registered in tr under the session ID "sid"
+func newTestSession(t *testing.T, tr *PollingTransport) *PollingConnection {
+	t.Helper()
+	conn, err := tr.HandleConnection(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	polling := conn.(*PollingConnection)
+	polling.sessionID = "sid"
+	tr.sessions.Set(polling.sessionID, polling)
+	return polling
+}
+
+// postBody sends body to the test session of tr, with unknown content length if chunked is true
+func postBody(tr *PollingTransport, body string, chunked bool) *httptest.ResponseRecorder {
+	var reader io.Reader = strings.NewReader(body)
+	if chunked {
+		reader = io.MultiReader(reader)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/?sid=sid", reader)
+	if chunked {
+		r.ContentLength = -1
+	}
+	w := httptest.NewRecorder()
+	tr.Serve(w, r)
+	return w
+}
+
+func TestPollingMaxPayload(t *testing.T) {
+	body := "21:42[\"message\",\"hello\"]"
+	for _, chunked := range []bool{false, true} {
+		tr := DefaultPollingTransport()
+		tr.MaxPayload = int64(len(body) - 1)
+		conn := newTestSession(t, tr)
+
+		w := postBody(tr, body, chunked)
+		var e ProtocolError
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			t.Fatalf("chunked %v: response %q is not an error: %v", chunked, w.Body.String(), err)
+		}
+		if w.Code != http.StatusRequestEntityTooLarge || e.Code != ErrorRequestTooLarge.Code ||
+			e.Message != ErrorRequestTooLarge.Message {
+			t.Fatalf("chunked %v: response is %d %q", chunked, w.Code, w.Body.String())
+		}
+		if _, err := conn.GetMessage(); err != ErrorPayloadTooLarge {
+			t.Fatalf("chunked %v: error is %v, expected %v", chunked, err, ErrorPayloadTooLarge)
+		}
+	}
+}
+
+func TestPollingUnlimitedPayload(t *testing.T) {
+	message := `42["message","` + strings.Repeat("x", 2*DefaultMaxPayload) + `"]`
+	for _, chunked := range []bool{false, true} {
+		tr := DefaultPollingTransport()
+		tr.MaxPayload = 0
+		conn := newTestSession(t, tr)
+
+		receivedC := make(chan string, 1)
+		go func() {
+			m, _ := conn.GetMessage()
+			receivedC <- m
+		}()
+
+		w := postBody(tr, fmt.Sprintf("%d:%s", len(message), message), chunked)
+		if w.Code != http.StatusOK || w.Body.String() != "ok" {
+			t.Fatalf("chunked %v: response is %d %q", chunked, w.Code, w.Body.String())
+		}
+		if m := <-receivedC; m != message {
+			t.Fatalf("chunked %v: received %d bytes, expected %d", chunked, len(m), len(message))
+		}
+	}
+}
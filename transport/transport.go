@@ -1,10 +1,24 @@
 package transport
 
 import (
+	"crypto/tls"
+	"errors"
 	"net/http"
 	"time"
 )
 
+var (
+	// ErrorConnectionClosed is returned by Connection.GetMessage when the remote side closes the connection
+	ErrorConnectionClosed = errors.New("connection closed")
+	// ErrorReceiveTimeout is returned by Connection.GetMessage when nothing was received within the receive timeout
+	ErrorReceiveTimeout = errors.New("timeout waiting for the message")
+	// ErrorPayloadTooLarge is returned by Connection.GetMessage when the remote side sent more than MaxPayload bytes
+	ErrorPayloadTooLarge = errors.New("payload too large")
+)
+
+// DefaultMaxPayload is a default maximum size of incoming message or polling request body in bytes
+const DefaultMaxPayload = 1e6
+
 // Connection represents an end-point connection with transport
 type Connection interface {
 	GetMessage() (message string, err error)
@@ -13,6 +27,29 @@ type Connection interface {
 	PingParams() (interval, timeout time.Duration)
 }
 
+// ConnectOptions are client connection options, zero values mean the transport's defaults
+type ConnectOptions struct {
+	Header    http.Header   // request headers added to the transport's Headers
+	TLSConfig *tls.Config   // overrides the transport's TLS configuration
+	Timeout   time.Duration // limits the connection establishing, zero means no limit
+}
+
+// OptionsConnector is a client transport connecting with options
+type OptionsConnector interface {
+	ConnectWith(url string, opts ConnectOptions) (conn Connection, err error)
+}
+
+// mergeHeaders returns a copy of headers with extra headers added
+func mergeHeaders(headers, extra http.Header) http.Header {
+	merged := make(http.Header, len(headers)+len(extra))
+	for _, h := range []http.Header{headers, extra} {
+		for key, values := range h {
+			merged[key] = append(merged[key], values...)
+		}
+	}
+	return merged
+}
+
 // Transport represents a connection transport
 type Transport interface {
 	Connect(url string) (conn Connection, err error)
@@ -4,16 +4,17 @@ import (
 	"crypto/tls"
 	"errors"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/mtfelian/golang-socketio/logging"
+	"github.com/mtfelian/golang-socketio/protocol"
 )
 
 const (
-	upgradeFailed = "Upgrade failed: "
-
 	wsDefaultPingInterval   = 30 * time.Second
 	wsDefaultPingTimeout    = 60 * time.Second
 	wsDefaultReceiveTimeout = 60 * time.Second
@@ -28,11 +29,8 @@ type WebsocketTransportParams struct {
 }
 
 var (
-	errBinaryMessage     = errors.New("binary messages are not supported")
-	errBadBuffer         = errors.New("buffer error")
-	errPacketWrong       = errors.New("wrong packet type error")
-	errMethodNotAllowed  = errors.New("method not allowed")
-	errHttpUpgradeFailed = errors.New("http upgrade failed")
+	errBadBuffer   = errors.New("buffer error")
+	errPacketWrong = errors.New("wrong packet type error")
 )
 
 // WebsocketConnection represents websocket connection
@@ -49,16 +47,23 @@ func (ws *WebsocketConnection) GetMessage() (string, error) {
 	msgType, reader, err := ws.socket.NextReader()
 	if err != nil {
 		logging.Log().Debug("WebsocketConnection.GetMessage() ws.socket.NextReader() err:", err)
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+			return "", ErrorConnectionClosed
+		}
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return "", ErrorReceiveTimeout
+		}
+		if err == websocket.ErrReadLimit {
+			return "", ErrorPayloadTooLarge
+		}
 		return "", err
 	}
 
-	// supports only text messages exchange
-	if msgType != websocket.TextMessage {
-		logging.Log().Debug("WebsocketConnection.GetMessage() returns errBinaryMessage")
-		return "", errBinaryMessage
-	}
-
 	data, err := ioutil.ReadAll(reader)
+	if err == websocket.ErrReadLimit {
+		logging.Log().Debug("WebsocketConnection.GetMessage() returns ErrorPayloadTooLarge")
+		return "", ErrorPayloadTooLarge
+	}
 	if err != nil {
 		logging.Log().Debug("WebsocketConnection.GetMessage() returns errBadBuffer")
 		return "", errBadBuffer
@@ -67,6 +72,12 @@ func (ws *WebsocketConnection) GetMessage() (string, error) {
 	text := string(data)
 	logging.Log().Debug("WebsocketConnection.GetMessage() text:", text)
 
+	// binary frames carry the message type byte like text ones
+	if msgType == websocket.BinaryMessage && !strings.HasPrefix(text, protocol.BinaryMessagePrefix) {
+		logging.Log().Debug("WebsocketConnection.GetMessage() returns errPacketWrong")
+		return "", errPacketWrong
+	}
+
 	// empty messages are not allowed
 	if len(text) == 0 {
 		logging.Log().Debug("WebsocketConnection.GetMessage() returns errPacketWrong")
@@ -84,7 +95,13 @@ func (ws *WebsocketConnection) WriteMessage(m string) error {
 	logging.Log().Debug("WebsocketConnection.WriteMessage() fired with:", m)
 	ws.socket.SetWriteDeadline(time.Now().Add(ws.transport.SendTimeout))
 
-	writer, err := ws.socket.NextWriter(websocket.TextMessage)
+	// binary messages are sent with the message type byte as engine.io-parser 2.x does
+	msgType := websocket.TextMessage
+	if strings.HasPrefix(m, protocol.BinaryMessagePrefix) {
+		msgType = websocket.BinaryMessage
+	}
+
+	writer, err := ws.socket.NextWriter(msgType)
 	if err != nil {
 		return err
 	}
@@ -115,15 +132,31 @@ type WebsocketTransport struct {
 	SendTimeout    time.Duration
 
 	BufferSize      int
+	MaxPayload      int64 // maximum size of incoming message on the server side in bytes, 0 means no limit
 	Headers         http.Header
 	TLSClientConfig *tls.Config
+
+	// CORS is used for checking origin of connections, AllowedOrigins and CheckOrigin only are used
+	CORS CORS
 }
 
 // Connect to the given url
 func (t *WebsocketTransport) Connect(url string) (Connection, error) {
-	dialer := websocket.Dialer{TLSClientConfig: t.TLSClientConfig}
-	socket, _, err := dialer.Dial(url, t.Headers)
+	return t.ConnectWith(url, ConnectOptions{})
+}
+
+// ConnectWith connects to the given url with options
+func (t *WebsocketTransport) ConnectWith(url string, opts ConnectOptions) (Connection, error) {
+	dialer := websocket.Dialer{TLSClientConfig: t.TLSClientConfig, HandshakeTimeout: opts.Timeout}
+	if opts.TLSConfig != nil {
+		dialer.TLSClientConfig = opts.TLSConfig
+	}
+
+	socket, resp, err := dialer.Dial(url, mergeHeaders(t.Headers, opts.Header))
 	if err != nil {
+		if err == websocket.ErrBadHandshake && resp != nil {
+			return nil, responseError(resp)
+		}
 		return nil, err
 	}
 	return &WebsocketConnection{socket, t}, nil
@@ -132,18 +165,27 @@ func (t *WebsocketTransport) Connect(url string) (Connection, error) {
 // HandleConnection
 func (t *WebsocketTransport) HandleConnection(w http.ResponseWriter, r *http.Request) (Connection, error) {
 	if r.Method != http.MethodGet {
-		http.Error(w, upgradeFailed+errMethodNotAllowed.Error(), http.StatusServiceUnavailable)
-		return nil, errMethodNotAllowed
+		WriteError(w, ErrorBadHandshakeMethod)
+		return nil, ErrorBadHandshakeMethod
 	}
 
+	upgradeErr := ErrorBadRequest
 	socket, err := (&websocket.Upgrader{
 		ReadBufferSize:  t.BufferSize,
 		WriteBufferSize: t.BufferSize,
+		CheckOrigin:     t.CORS.allowed,
+		Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+			logging.Log().Debug("WebsocketTransport.HandleConnection() upgrade failed:", reason)
+			if status == http.StatusForbidden {
+				upgradeErr = ErrorForbidden
+			}
+			WriteError(w, upgradeErr)
+		},
 	}).Upgrade(w, r, nil)
 	if err != nil {
-		http.Error(w, upgradeFailed+err.Error(), http.StatusServiceUnavailable)
-		return nil, errHttpUpgradeFailed
+		return nil, upgradeErr
 	}
+	socket.SetReadLimit(t.MaxPayload)
 
 	return &WebsocketConnection{socket, t}, nil
 }
@@ -159,6 +201,7 @@ func DefaultWebsocketTransport() *WebsocketTransport {
 		ReceiveTimeout: wsDefaultReceiveTimeout,
 		SendTimeout:    wsDefaultSendTimeout,
 		BufferSize:     wsDefaultBufferSize,
+		MaxPayload:     DefaultMaxPayload,
 	}
 }
 
@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// binary frames as sent by engine.io 2.x with socket.io-msgpack-parser 2.x, starting with the message type byte
+const (
+	// client: socket.emit("hello", "world", 1)
+	jsClientFrame = "\x04\x84\xa4type\x02\xa4data\x93\xa5hello\xa5world\x01\xa7options\x81\xa8compress\xc3\xa3nsp\xa1/"
+	// server: socket.emit("hello", "world", 1)
+	jsServerFrame = "\x04\x83\xa4type\x02\xa4data\x93\xa5hello\xa5world\x01\xa3nsp\xa1/"
+)
+
+func TestWebsocketBinaryFrames(t *testing.T) {
+	tr := DefaultWebsocketTransport()
+	receivedC, errC := make(chan string, 1), make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := tr.HandleConnection(w, r)
+		if err != nil {
+			errC <- err
+			return
+		}
+		defer conn.Close()
+
+		if err := conn.WriteMessage(jsServerFrame); err != nil {
+			errC <- err
+			return
+		}
+		m, err := conn.GetMessage()
+		if err != nil {
+			errC <- err
+			return
+		}
+		receivedC <- m
+	}))
+	defer ts.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http://", "ws://", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	msgType, data, err := socket.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgType != websocket.BinaryMessage || string(data) != jsServerFrame {
+		t.Fatalf("received frame %d %q, expected binary %q", msgType, data, jsServerFrame)
+	}
+
+	if err := socket.WriteMessage(websocket.BinaryMessage, []byte(jsClientFrame)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case m := <-receivedC:
+		if m != jsClientFrame {
+			t.Fatalf("received message %q, expected %q", m, jsClientFrame)
+		}
+	case err := <-errC:
+		t.Fatal(err)
+	}
+}
+
+func TestWebsocketBinaryFrameWithoutType(t *testing.T) {
+	tr := DefaultWebsocketTransport()
+	errC := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := tr.HandleConnection(w, r)
+		if err != nil {
+			errC <- err
+			return
+		}
+		defer conn.Close()
+
+		_, err = conn.GetMessage()
+		errC <- err
+	}))
+	defer ts.Close()
+
+	socket, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http://", "ws://", 1), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+
+	if err := socket.WriteMessage(websocket.BinaryMessage, []byte(jsClientFrame[1:])); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errC; err != errPacketWrong {
+		t.Fatalf("error is %v, expected %v", err, errPacketWrong)
+	}
+}
@@ -0,0 +1,99 @@
+package gosocketio
+
+import "sync"
+
+// userRegistry maps user ids to channels bound to them, a user may have several channels
+type userRegistry struct {
+	channels *roomRegistry // user ids are stored as room names
+}
+
+// newUserRegistry returns an initialized users registry
+func newUserRegistry() *userRegistry { return &userRegistry{channels: newRoomRegistry()} }
+
+// join binds channel c to the user with the given id
+func (r *userRegistry) join(id string, c *Channel) { r.channels.join(id, c) }
+
+// leave unbinds channel c from the user with the given id
+func (r *userRegistry) leave(id string, c *Channel) { r.channels.leave(id, c) }
+
+// replace channel old with channel c for the user with the given id, if old is bound to the user
+func (r *userRegistry) replace(id string, old, c *Channel) { r.channels.replace(id, old, c) }
+
+// list returns a snapshot of channels bound to the user with the given id
+func (r *userRegistry) list(id string) []*Channel { return r.channels.list(id) }
+
+// SetUser binds the channel to the user with the given id and attaches user metadata to it.
+// The user becomes present in all rooms the channel joins, a user with several channels
+// in the same room is present there once. Empty id unbinds the channel
+func (c *Channel) SetUser(id string, meta interface{}) {
+	c.userMu.Lock()
+	oldID := c.userID
+	c.userID, c.userMeta = id, meta
+	c.userMu.Unlock()
+
+	if c.server == nil {
+		return
+	}
+
+	if oldID != id {
+		if oldID != "" {
+			c.server.users.leave(oldID, c)
+		}
+		if id != "" {
+			// join under the alive lock: a concurrently closed channel is either not joined or removed by onDisconnection
+			c.aliveMu.Lock()
+			if c.alive {
+				c.server.users.join(id, c)
+			}
+			c.aliveMu.Unlock()
+		}
+	}
+
+	c.roomsMu.Lock()
+	rooms := make([]string, 0, len(c.rooms))
+	for room := range c.rooms {
+		rooms = append(rooms, room)
+	}
+	c.roomsMu.Unlock()
+
+	for _, room := range rooms {
+		if oldID != "" && oldID != id {
+			c.server.presence.leave(c, oldID, room)
+		}
+		if id != "" {
+			c.server.presence.join(c, id, meta, room)
+		}
+	}
+}
+
+// User returns user id and metadata attached to the channel
+func (c *Channel) User() (string, interface{}) {
+	c.userMu.RLock()
+	defer c.userMu.RUnlock()
+	return c.userID, c.userMeta
+}
+
+// UserChannels returns a list of channels bound to the user with the given id
+func (s *Server) UserChannels(id string) []*Channel { return s.users.list(id) }
+
+// EmitToUser an event with given name and payload to all channels of the user with the given id
+func (s *Server) EmitToUser(id, name string, payload interface{}) {
+	for _, cn := range s.users.list(id) {
+		if cn.IsAlive() {
+			go cn.Emit(name, payload)
+		}
+	}
+}
+
+// DisconnectUser disconnects all channels of the user with the given id with ReasonServerDisconnect
+func (s *Server) DisconnectUser(id string) {
+	var wg sync.WaitGroup
+	for _, cn := range s.users.list(id) {
+		wg.Add(1)
+		go func(c *Channel) {
+			defer wg.Done()
+			c.Disconnect(ReasonServerDisconnect)
+		}(cn)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,127 @@
+package gosocketio
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestUserChannelsAcrossUpgrade(t *testing.T) {
+	s := NewServer()
+	s.On(OnConnection, func(c *Channel) { c.SetUser("user", nil) })
+	disconnectedC := make(chan *Channel, 1)
+	s.On(OnDisconnection, func(c *Channel) { disconnectedC <- c })
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+	sid := pollingHandshake(t, client, ts.URL)
+	if body := pollingGet(t, client, ts.URL, sid); body != "2:40" {
+		t.Fatalf("connect packet is %q", body)
+	}
+	polling, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channels := s.UserChannels("user"); len(channels) != 1 || channels[0] != polling {
+		t.Fatalf("user channels are %v, expected the polling one", channels)
+	}
+
+	// the pending poll is answered with noop at upgrade
+	noopC := make(chan string, 1)
+	go func() {
+		resp, err := client.Get(ts.URL + "/socket.io/?EIO=3&transport=polling&sid=" + sid)
+		if err != nil {
+			noopC <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		noopC <- string(body)
+	}()
+
+	socket, _, err := websocket.DefaultDialer.Dial(strings.Replace(ts.URL, "http://", "ws://", 1)+
+		"/socket.io/?EIO=3&transport=websocket&sid="+sid, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer socket.Close()
+	if err := socket.WriteMessage(websocket.TextMessage, []byte("2probe")); err != nil {
+		t.Fatal(err)
+	}
+	if _, probe, err := socket.ReadMessage(); err != nil || string(probe) != "3probe" {
+		t.Fatalf("probe response is %q, %v", probe, err)
+	}
+	if err := socket.WriteMessage(websocket.TextMessage, []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case body := <-noopC:
+		if body != "1:6" {
+			t.Fatalf("pending poll is answered with %q", body)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending poll is not answered")
+	}
+
+	upgraded, err := s.GetChannel(sid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upgraded == polling {
+		t.Fatal("channel is not replaced at upgrade")
+	}
+	if channels := s.UserChannels("user"); len(channels) != 1 || channels[0] != upgraded {
+		t.Fatalf("user channels are %v after upgrade, expected the websocket one", channels)
+	}
+	if id, _ := upgraded.User(); id != "user" {
+		t.Fatalf("upgraded channel user is %q", id)
+	}
+
+	socket.Close()
+	select {
+	case c := <-disconnectedC:
+		if c != upgraded {
+			t.Fatal("disconnected channel is not the upgraded one")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("upgraded channel is not disconnected")
+	}
+	if channels := s.UserChannels("user"); len(channels) != 0 {
+		t.Fatalf("user channels are %v after disconnection", channels)
+	}
+}
+
+func TestDisconnectUser(t *testing.T) {
+	s := NewServer()
+	s.On(OnConnection, func(c *Channel) { c.SetUser("user", nil) })
+	serverReasonC := make(chan DisconnectReason, 1)
+	s.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { serverReasonC <- reason })
+
+	ts, client, _ := connectTestClient(t, s)
+	defer ts.Close()
+	defer client.Close()
+	clientReasonC := make(chan DisconnectReason, 1)
+	client.On(OnDisconnection, func(c *Channel, reason DisconnectReason) { clientReasonC <- reason })
+
+	s.DisconnectUser("user")
+	for side, reasonC := range map[string]chan DisconnectReason{"server": serverReasonC, "client": clientReasonC} {
+		select {
+		case reason := <-reasonC:
+			if reason != ReasonServerDisconnect {
+				t.Fatalf("%s disconnect reason is %v, expected %v", side, reason, ReasonServerDisconnect)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s is not disconnected", side)
+		}
+	}
+	if channels := s.UserChannels("user"); len(channels) != 0 {
+		t.Fatalf("user channels are %v after disconnection", channels)
+	}
+}
@@ -0,0 +1,67 @@
+package gosocketio
+
+import (
+	"sync"
+)
+
+// values is a concurrency-safe key/value storage living as long as the socket connection
+type values struct {
+	m  map[string]interface{}
+	mu sync.RWMutex
+}
+
+// newValues returns an initialized values storage
+func newValues() *values { return &values{m: make(map[string]interface{})} }
+
+// clear the storage
+func (v *values) clear() {
+	v.mu.Lock()
+	v.m = make(map[string]interface{})
+	v.mu.Unlock()
+}
+
+// Set a value for the given key in the channel storage
+func (c *Channel) Set(key string, value interface{}) {
+	c.values.mu.Lock()
+	c.values.m[key] = value
+	c.values.mu.Unlock()
+}
+
+// Get returns a value for the given key from the channel storage, the second value is false if key not exists
+func (c *Channel) Get(key string) (interface{}, bool) {
+	c.values.mu.RLock()
+	defer c.values.mu.RUnlock()
+	value, ok := c.values.m[key]
+	return value, ok
+}
+
+// Delete the given key from the channel storage
+func (c *Channel) Delete(key string) {
+	c.values.mu.Lock()
+	delete(c.values.m, key)
+	c.values.mu.Unlock()
+}
+
+// GetString returns a value for the given key as a string, the second value is false
+// if key not exists or the value is not a string
+func (c *Channel) GetString(key string) (string, bool) {
+	value, _ := c.Get(key)
+	s, ok := value.(string)
+	return s, ok
+}
+
+// GetInt returns a value for the given key as an int, the second value is false
+// if key not exists or the value is not an int
+func (c *Channel) GetInt(key string) (int, bool) {
+	value, _ := c.Get(key)
+	i, ok := value.(int)
+	return i, ok
+}
+
+// GetBool returns a value for the given key as a bool, the second value is false
+// if key not exists or the value is not a bool
+func (c *Channel) GetBool(key string) (bool, bool) {
+	value, _ := c.Get(key)
+	b, ok := value.(bool)
+	return b, ok
+}
@@ -0,0 +1,100 @@
+package gosocketio
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// wildcard is a pattern symbol matching any sequence of characters in event names
+const wildcard = "*"
+
+// AnyHandler is a catch-all handler receiving any event name with it's raw arguments
+type AnyHandler func(c *Channel, name string, args json.RawMessage)
+
+// OnAny registers f to be called for every incoming event before the event's own handler
+func (e *event) OnAny(f AnyHandler) {
+	e.handlersMu.Lock()
+	e.anyHandlers = append(e.anyHandlers, f)
+	e.handlersMu.Unlock()
+}
+
+// OnAnyOutgoing registers f to be called for every emitted event and ack request
+func (e *event) OnAnyOutgoing(f AnyHandler) {
+	e.handlersMu.Lock()
+	e.anyOutgoing = append(e.anyOutgoing, f)
+	e.handlersMu.Unlock()
+}
+
+// callAny calls catch-all incoming handlers
+func (e *event) callAny(c *Channel, name, args string) {
+	e.handlersMu.RLock()
+	anyHandlers := e.anyHandlers
+	e.handlersMu.RUnlock()
+
+	for _, f := range anyHandlers {
+		f(c, name, json.RawMessage(args))
+	}
+}
+
+// callAnyOutgoing calls catch-all outgoing handlers
+func (e *event) callAnyOutgoing(c *Channel, name, args string) {
+	e.handlersMu.RLock()
+	anyOutgoing := e.anyOutgoing
+	e.handlersMu.RUnlock()
+
+	for _, f := range anyOutgoing {
+		f(c, name, json.RawMessage(args))
+	}
+}
+
+// isPattern returns true if the event name is a pattern
+func isPattern(name string) bool { return strings.Contains(name, wildcard) }
+
+// matchHandlers returns handlers for the incoming event name with the key they are bound to: handlers bound
+// exactly to this name, or else handlers of the most specific matching pattern, i.e. having the most
+// non-wildcard characters, and the earliest registered one of equally specific patterns
+func (e *event) matchHandlers(name string) (string, []*handler) {
+	e.handlersMu.RLock()
+	defer e.handlersMu.RUnlock()
+
+	if handlers, ok := e.handlers[name]; ok {
+		return name, handlers
+	}
+
+	best, bestSpecificity := "", -1
+	for _, pattern := range e.patterns {
+		specificity := len(pattern) - strings.Count(pattern, wildcard)
+		if specificity > bestSpecificity && matchPattern(pattern, name) {
+			best, bestSpecificity = pattern, specificity
+		}
+	}
+
+	if bestSpecificity < 0 {
+		return "", nil
+	}
+	return best, e.handlers[best]
+}
+
+// matchPattern reports whether name matches the pattern where each wildcard matches any sequence of characters
+func matchPattern(pattern, name string) bool {
+	parts := strings.Split(pattern, wildcard)
+	if len(parts) == 1 {
+		return pattern == name
+	}
+
+	if !strings.HasPrefix(name, parts[0]) {
+		return false
+	}
+	name = name[len(parts[0]):]
+
+	last := len(parts) - 1
+	for _, part := range parts[1:last] {
+		i := strings.Index(name, part)
+		if i < 0 {
+			return false
+		}
+		name = name[i+len(part):]
+	}
+
+	return strings.HasSuffix(name, parts[last])
+}